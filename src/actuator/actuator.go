@@ -1,9 +1,11 @@
 package actuator
 
 import (
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"path"
 	"strings"
@@ -16,6 +18,15 @@ const (
 	contentTypeTextPlain = "text/plain"
 )
 
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
 type httpWriter func(text string)
 type handleFunc func(writer httpWriter, body string)
 type handler struct {
@@ -23,16 +34,16 @@ type handler struct {
 	match func(r *http.Request) bool
 }
 
-func RegisterHttpHandler(basePath string, readWriter register.ReadWriter, actuatorsConfig config.Actuators, registersConfig config.Registers) {
+func RegisterHttpHandler(basePath string, readWriter register.ReadWriter, actuatorsConfig config.Actuators, registry *register.Registry) {
 	log.Infof("Serving %d actuators at path %s", len(actuatorsConfig), basePath)
 	for actuatorName, actuatorConfig := range actuatorsConfig {
 		actuatorConfig := actuatorConfig // prevent stupid capture by reference
 		registerHandlers(path.Join(basePath, actuatorName),
 			matchPost(func(writer httpWriter, body string) {
-				writeValue(writer, actuatorConfig, body, readWriter, registersConfig)
+				writeValue(writer, actuatorConfig, body, readWriter, registry)
 			}),
 			matchGet(func(writer httpWriter) {
-				readValue(writer, actuatorConfig, readWriter, registersConfig)
+				readValue(writer, actuatorConfig, readWriter, registry)
 			}),
 		)
 	}
@@ -63,7 +74,11 @@ func registerHandlers(path string, handlers ...*handler) {
 			if err := recover(); err != nil {
 				errMessage := fmt.Sprintf("%v", err)
 				log.Error(errMessage)
-				w.WriteHeader(http.StatusInternalServerError)
+				status := http.StatusInternalServerError
+				if httpErr, ok := err.(*httpError); ok {
+					status = httpErr.status
+				}
+				w.WriteHeader(status)
 				_, err := w.Write([]byte(errMessage))
 				util.PanicOnError(err)
 			}
@@ -89,16 +104,16 @@ func registerHandlers(path string, handlers ...*handler) {
 	})
 }
 
-func readValue(writer httpWriter, actuatorConfig *config.Actuator, reader register.Reader, registersConfig config.Registers) {
+func readValue(writer httpWriter, actuatorConfig *config.Actuator, reader register.Reader, registry *register.Registry) {
 	if expressionValue := actuatorConfig.ValueFromExpression; expressionValue != nil {
-		value, err := expressionValue.Evaluate(newRegisterValueProvider(registersConfig, reader))
+		value, err := expressionValue.Evaluate(newRegisterValueProvider(registry, reader))
 		util.PanicOnError(err)
 		writer(fmt.Sprintf("%v", value))
 		return
 	}
 	if len(actuatorConfig.Registers) == 1 {
 		registerName, _ := util.GetOnlyMapElement(actuatorConfig.Registers)
-		value, err := register.NewFromConfig(registersConfig[registerName]).ReadString(reader)
+		value, err := registry.MustGet(registerName).ReadString(reader)
 		util.PanicOnError(err)
 		writer(value)
 		return
@@ -106,24 +121,39 @@ func readValue(writer httpWriter, actuatorConfig *config.Actuator, reader regist
 	panic(fmt.Sprintf("cannot read actuator %s", actuatorConfig.Name))
 }
 
-func newRegisterValueProvider(registersConfig config.Registers, reader register.Reader) config.RegisterValueProvider {
-	return func(registerName string) float64 {
-		value, err := register.NewFromConfig(registersConfig[registerName]).ReadFloat64(reader, 0)
-		util.PanicOnError(err)
-		return value
+func newRegisterValueProvider(registry *register.Registry, reader register.Reader) config.RegisterValueProvider {
+	return func(registerName string) []float64 {
+		reg := registry.MustGet(registerName)
+		values := make([]float64, reg.Length())
+		for i := range values {
+			value, err := reg.ReadFloat64(reader, uint16(i))
+			var invalidValueErr *register.InvalidValueError
+			if errors.As(err, &invalidValueErr) {
+				value = math.NaN()
+			} else {
+				util.PanicOnError(err)
+			}
+			values[i] = value
+		}
+		return values
 	}
 }
 
-func writeValue(httpWriter httpWriter, actuatorConfig *config.Actuator, value string, readWriter register.ReadWriter, registersConfig config.Registers) {
+func writeValue(httpWriter httpWriter, actuatorConfig *config.Actuator, value string, readWriter register.ReadWriter, registry *register.Registry) {
 	registerNames := util.GetKeys(actuatorConfig.Registers)
-	registers := register.NewFromConfigs(registersConfig, registerNames...)
+	registers, err := registry.Select(registerNames...)
+	util.PanicOnError(err)
 	writtenRegisterValues, err := registers.Write(readWriter, func(registerName string) (string, *float64) {
 		if mapValue := actuatorConfig.Registers[registerName]; mapValue.ByFunction != nil {
 			return value, util.PointerTo(mapValue.ByFunction(value))
 		}
 		return value, nil
-	}, newRegisterValueProvider(registersConfig, readWriter))
+	}, newRegisterValueProvider(registry, readWriter))
+	var outOfRangeErr *register.OutOfRangeError
+	if errors.As(err, &outOfRangeErr) {
+		panic(&httpError{http.StatusBadRequest, err})
+	}
 	util.PanicOnError(err)
 	log.Infof("Registers after write: %s", writtenRegisterValues)
-	readValue(httpWriter, actuatorConfig, writtenRegisterValues, registersConfig)
+	readValue(httpWriter, actuatorConfig, writtenRegisterValues, registry)
 }
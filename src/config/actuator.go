@@ -1,9 +1,9 @@
 package config
 
 import (
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 	"sungrow-prometheus-exporter/src/util"
-	"time"
 )
 
 type Actuators map[string]*Actuator
@@ -12,6 +12,26 @@ func (actuators *Actuators) UnmarshalYAML(node *yaml.Node) error {
 	return unmarshalNamedSequenceToMap[Actuator](node, (*map[string]*Actuator)(actuators))
 }
 
+// bind the references to the expressions of the actuators,
+// checking the referenced metrics
+func (actuators Actuators) bind(refs *references) error {
+	for _, actuator := range actuators {
+		for _, mapValue := range actuator.Registers {
+			bindFunction(mapValue.refs, refs)
+		}
+		if expressionValue := actuator.ValueFromExpression; expressionValue != nil {
+			err := expressionValue.bind(refs)
+			if err == nil {
+				err = checkMetricReferences(refs.metrics, expressionValue.metricNames, map[string]bool{})
+			}
+			if err != nil {
+				return errors.Wrapf(err, "invalid actuator %s", actuator.Name)
+			}
+		}
+	}
+	return nil
+}
+
 type Actuator struct {
 	Name                string `yaml:"name"`
 	Registers           map[string]ActuatorRegisterMapValue
@@ -24,6 +44,7 @@ func (a Actuator) GetKey() string {
 
 type ActuatorRegisterMapValue struct {
 	ByFunction func(value string) float64
+	refs       *references
 }
 
 func (mapValue *ActuatorRegisterMapValue) UnmarshalYAML(node *yaml.Node) error {
@@ -33,20 +54,24 @@ func (mapValue *ActuatorRegisterMapValue) UnmarshalYAML(node *yaml.Node) error {
 		return err
 	}
 	if len(m) == 1 {
-		function, err := convertOneElementMapToFunction[string](m, util.Compile,
-			util.Env("timeParse", func(value, layout, timezone string) time.Time {
-				parse, err := time.Parse(layout, value)
-				util.PanicOnError(err)
-				location, err := time.LoadLocation(timezone)
-				util.PanicOnError(err)
-				return parse.In(location)
-			}),
-		)
+		mapValue.refs = &references{}
+		function, err := convertOneElementMapToFunction[string](m, util.Compile, mapValue.refs)
 		if err != nil {
-			return err
+			return nodeError(node, err)
 		}
 		mapValue.ByFunction = function
 
 	}
 	return nil
 }
+
+func (actuators Actuators) FindRegisterNames(metrics Metrics) []string {
+	var r []string
+	for _, actuator := range actuators {
+		r = append(r, util.GetKeys(actuator.Registers)...)
+		if expressionValue := actuator.ValueFromExpression; expressionValue != nil {
+			r = append(r, expressionValue.allRegisterNames(metrics)...)
+		}
+	}
+	return r
+}
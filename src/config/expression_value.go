@@ -1,13 +1,15 @@
 package config
 
 import (
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/conf"
 	"gopkg.in/yaml.v3"
-	"sungrow-prometheus-exporter/src/util"
-	"time"
+	"reflect"
 )
 
 type ExpressionValue struct {
 	registerFunc
+	line int
 }
 
 func (v *ExpressionValue) UnmarshalYAML(node *yaml.Node) error {
@@ -16,30 +18,36 @@ func (v *ExpressionValue) UnmarshalYAML(node *yaml.Node) error {
 	if err != nil {
 		return err
 	}
-	regFunc, err := newRegisterFunc(s, util.Env(
-		"timeDate", func(args ...interface{}) (interface{}, error) {
-			location, err := time.LoadLocation(args[6].(string))
-			if err != nil {
-				return nil, err
-			}
-			return time.Date(
-				int(args[0].(float64)),
-				time.Month(args[1].(float64)),
-				int(args[2].(float64)),
-				int(args[3].(float64)),
-				int(args[4].(float64)),
-				int(args[5].(float64)),
-				0,
-				location,
-			), nil
-		}))
+	regFunc, err := newRegisterFunc(s, nil)
 	if err != nil {
-		return err
+		return nodeError(node, err)
 	}
-	*v = ExpressionValue{*regFunc}
+	*v = ExpressionValue{*regFunc, node.Line}
+	return nil
+}
+
+// asMap expects the expression to return a map, like {'a': 1, 'b': 2}
+func asMap() expr.Option {
+	return func(c *conf.Config) {
+		c.Expect = reflect.Map
+	}
+}
+
+// expect recompiles the expression with the expected result type,
+// as it depends on where the expression is used
+func (v *ExpressionValue) expect(op expr.Option) error {
+	regFunc, err := v.registerFunc.recompile(op)
+	if err != nil {
+		return lineError(v.line, err)
+	}
+	v.registerFunc = *regFunc
 	return nil
 }
 
 func (v *ExpressionValue) Evaluate(registerValue RegisterValueProvider) (interface{}, error) {
-	return v.registerFunc.evaluate(registerValue)
+	return v.registerFunc.evaluate(registerValue, nil)
+}
+
+func (v *ExpressionValue) String() string {
+	return v.source
 }
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestExpressionTypeChecking(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"metric returning string", `
+- name: a
+  value:
+    fromExpression: "'text'"`, "line 4: cannot compile ''text'': expected float64, but got string"},
+		{"unknown function in unrelated branch", `
+- name: a
+  value:
+    fromExpression: "true ? 1 : unknown()"`, "line 4: cannot compile 'true ? 1 : unknown()': unknown func unknown"},
+		{"register name not literal", `
+- name: a
+  value:
+    fromExpression: "register('a' + 'b')"`, "first argument of register must be a string literal"},
+		{"index returning string", `
+- name: a
+  value:
+    fromRegister:
+      name: array
+      indexFromExpression: "'first'"`, "line 6: cannot compile ''first'': expected int64, but got string"},
+		{"label may return string", `
+- name: a
+  value:
+    fromExpression: 1
+  labels:
+    - name: l
+      value:
+        fromExpression: "'text'"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := unmarshalMetrics(tt.input, nil)
+			if len(tt.err) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestRegisterValidation_UnmarshalYAML(t *testing.T) {
+	var registers Registers
+	err := yaml.Unmarshal([]byte(`
+- name: limit
+  validation:
+    limit: "limit * 2"`), &registers)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 4: cannot compile 'limit * 2': expected bool, but got float64")
+
+	err = yaml.Unmarshal([]byte(`
+- name: limit
+  validation:
+    limit: "limit <= register('max')"`), &registers)
+	assert.NoError(t, err)
+	err = registers["limit"].Validation.Validate(3, func(registerName string) []float64 {
+		return []float64{2}
+	})
+	assert.EqualError(t, err, "invalid value '3.000000'")
+}
@@ -1,7 +1,15 @@
 package config
 
 import (
+	"fmt"
+	"github.com/antonmedv/expr"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
+	"math"
+	"regexp"
+	"sungrow-prometheus-exporter/src/util"
+	"time"
 )
 
 type Metrics map[string]*Metric
@@ -10,26 +18,481 @@ func (metrics *Metrics) UnmarshalYAML(node *yaml.Node) error {
 	return unmarshalNamedSequenceToMap[Metric](node, (*map[string]*Metric)(metrics))
 }
 
+// bind the references to the expressions of the metrics,
+// checking the metrics which refer to registers and other metrics
+func (metrics Metrics) bind(refs *references) error {
+	for name, metric := range metrics {
+		err := metric.bind(refs)
+		if err == nil {
+			err = checkMetricReferences(metrics, []string{name}, map[string]bool{})
+		}
+		if err == nil {
+			err = metric.checkUnits(refs.registers)
+		}
+		if err == nil {
+			err = metric.checkValue(refs.registers)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "invalid metric %s", metric.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Metric) bind(refs *references) error {
+	var registerFuncs []*registerFunc
+	registerFuncs = append(registerFuncs, m.Value.registerFuncs()...)
+	for _, label := range m.Labels {
+		registerFuncs = append(registerFuncs, label.Value.registerFuncs()...)
+	}
+	if m.IndexLabel != nil && m.IndexLabel.Skip != nil {
+		registerFuncs = append(registerFuncs, &m.IndexLabel.Skip.registerFunc)
+	}
+	for _, f := range registerFuncs {
+		if err := f.bind(refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type Metric struct {
-	Name   string     `yaml:"name"`
-	Help   string     `yaml:"help"`
-	Alias  string     `yaml:"alias"`
-	Type   MetricType `yaml:"type"`
-	Value  *Value     `yaml:"value"`
-	Labels []*Label   `yaml:"labels"`
+	Name  string     `yaml:"name"`
+	Help  string     `yaml:"help"`
+	Alias string     `yaml:"alias"`
+	Type  MetricType `yaml:"type"`
+	// Unit overrides the unit of the register, also used for expressions
+	Unit string `yaml:"unit"`
+	// OutputUnit converts values to another unit of the same dimension
+	OutputUnit string `yaml:"outputUnit"`
+	Value      *Value `yaml:"value"`
+	// SeriesLabel is required if the value expression returns a map,
+	// then there's one series per key labelled with the key
+	SeriesLabel string   `yaml:"seriesLabel"`
+	Labels      []*Label `yaml:"labels"`
+	// Registers are the labels of info metrics, which have no value
+	Registers    []*InfoRegister      `yaml:"registers"`
+	DailyCounter *DailyCounterOptions `yaml:"dailyCounter"`
+	Integral     *IntegralOptions     `yaml:"integral"`
+	// Windows declares the windows of window functions like avg_over,
+	// which bounds the values kept by the poller
+	Windows []time.Duration `yaml:"windows"`
+	// IndexLabel maps the indices of array registers to label values
+	IndexLabel *IndexLabel `yaml:"indexLabel"`
 }
 
 func (m Metric) GetKey() string {
 	return m.Name
 }
 
+func (m *Metric) checkUnits(registers Registers) error {
+	if len(m.Unit) > 0 {
+		if _, err := GetUnit(m.Unit); err != nil {
+			return err
+		}
+	}
+	if len(m.OutputUnit) == 0 && m.Type != Integral {
+		return nil
+	}
+	unitName := m.Unit
+	if len(unitName) == 0 && m.Value != nil && m.Value.FromRegister != nil {
+		registerName, _ := SplitRegisterName(m.Value.FromRegister.Name)
+		if registerConfig, ok := registers[registerName]; ok {
+			unitName = registerConfig.Unit
+		}
+	}
+	if len(unitName) == 0 && m.Type == Integral {
+		return errors.New("integral requires a unit")
+	}
+	if len(unitName) == 0 {
+		return errors.New("outputUnit requires a unit")
+	}
+	unit, err := GetUnit(unitName)
+	if err != nil {
+		return err
+	}
+	if m.Type == Integral {
+		if unit, _, err = unit.Integral(); err != nil {
+			return err
+		}
+	}
+	if len(m.OutputUnit) == 0 {
+		return nil
+	}
+	outputUnit, err := GetUnit(m.OutputUnit)
+	if err != nil {
+		return err
+	}
+	_, err = unit.ConvertTo(outputUnit)
+	return err
+}
+
+func (m *Metric) checkValue(registers Registers) error {
+	if (m.Type == DailyCounter) != (m.DailyCounter != nil) {
+		return errors.New("dailyCounter is required for and only allowed for type dailyCounter")
+	}
+	if m.DailyCounter != nil {
+		if err := m.DailyCounter.check(registers); err != nil {
+			return err
+		}
+	}
+	if m.Integral != nil && m.Type != Integral {
+		return errors.New("integral is only allowed for type integral")
+	}
+	if m.Type == Integral {
+		if err := m.checkIntegralLabels(); err != nil {
+			return err
+		}
+	}
+	if m.Type == Info {
+		return m.checkInfoRegisters()
+	}
+	if len(m.Registers) > 0 {
+		return errors.New("registers are only allowed for info metrics")
+	}
+	if m.Value == nil {
+		return errors.New("value is required")
+	}
+	if err := m.checkWindows(); err != nil {
+		return err
+	}
+	if m.IndexLabel != nil {
+		if err := m.checkIndexLabel(registers); err != nil {
+			return err
+		}
+	}
+	if m.Type == StateSet {
+		return m.checkStateSetValue(registers)
+	}
+	if len(m.SeriesLabel) > 0 {
+		if m.Value.FromExpression == nil {
+			return errors.New("seriesLabel requires value fromExpression")
+		}
+		return m.Value.FromExpression.expect(asMap())
+	}
+	if m.Value.FromExpression != nil {
+		return m.Value.FromExpression.expect(expr.AsFloat64())
+	}
+	if m.Value.Static != nil {
+		return errors.New("static value is only allowed for labels")
+	}
+	return nil
+}
+
+func (m *Metric) checkWindows() error {
+	for _, label := range m.Labels {
+		if label.Value.UsesWindowFunctions() {
+			return errors.New("window functions are only allowed in the value of the metric")
+		}
+	}
+	if registerValue := m.Value.FromRegister; registerValue != nil && registerValue.IndexFromExpression != nil &&
+		len(registerValue.IndexFromExpression.windows.calls) > 0 {
+		return errors.New("window functions are not allowed in indexFromExpression")
+	}
+	if m.Value.FromExpression == nil {
+		if len(m.Windows) > 0 {
+			return errors.New("windows require value fromExpression")
+		}
+		return nil
+	}
+	for _, call := range m.Value.FromExpression.windows.calls {
+		if call.window > 0 && !slices.Contains(m.Windows, call.window) {
+			return fmt.Errorf("window %s of %s is not declared in windows", call.window, call.function)
+		}
+	}
+	return nil
+}
+
+func (m *Metric) checkIntegralLabels() error {
+	if !m.Integral.IsSplit() {
+		return nil
+	}
+	if m.SeriesLabel == IntegralDirectionLabel || m.IndexLabel != nil && m.IndexLabel.GetName() == IntegralDirectionLabel {
+		return fmt.Errorf("duplicate label %s", IntegralDirectionLabel)
+	}
+	for _, label := range m.Labels {
+		if label.Name == IntegralDirectionLabel {
+			return fmt.Errorf("duplicate label %s", label.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Metric) checkIndexLabel(registers Registers) error {
+	registerValue := m.Value.FromRegister
+	if registerValue == nil || registerValue.HasIndex() {
+		return errors.New("indexLabel requires value fromRegister without index")
+	}
+	registerConfig, ok := registers[registerValue.Name]
+	if !ok || registerConfig.Length <= 1 {
+		return fmt.Errorf("indexLabel requires an array register, %s is none", registerValue.Name)
+	}
+	for _, label := range m.Labels {
+		if label.Name == m.IndexLabel.GetName() {
+			return fmt.Errorf("duplicate label %s", label.Name)
+		}
+	}
+	return m.IndexLabel.check(registers, int(registerConfig.Length))
+}
+
+func (m *Metric) checkStateSetValue(registers Registers) error {
+	registerValue := m.Value.FromRegister
+	if registerValue == nil {
+		return errors.New("stateset requires value fromRegister")
+	}
+	if registerValue.HasIndex() || len(m.SeriesLabel) > 0 {
+		return errors.New("stateset does not support index or seriesLabel")
+	}
+	_, err := registers.findEnumMap(registerValue.Name)
+	return err
+}
+
+func (m *Metric) checkInfoRegisters() error {
+	if m.Value != nil {
+		return errors.New("info metric cannot have a value")
+	}
+	if len(m.Registers) == 0 {
+		return errors.New("info metric requires registers")
+	}
+	labelNames := map[string]bool{}
+	for _, label := range m.Labels {
+		labelNames[label.Name] = true
+	}
+	for _, infoRegister := range m.Registers {
+		if labelNames[infoRegister.Label] {
+			return fmt.Errorf("duplicate label %s", infoRegister.Label)
+		}
+		labelNames[infoRegister.Label] = true
+	}
+	return nil
+}
+
 type MetricType string
 
 const (
 	Gauge   MetricType = "gauge"
 	Counter MetricType = "counter"
+	// StateSet has one series per enum entry of the register,
+	// the current state has value 1
+	StateSet MetricType = "stateset"
+	// Info exports the string values of registers as labels of a constant series
+	Info MetricType = "info"
+	// DailyCounter is a counter which resets at midnight of the inverter clock
+	DailyCounter MetricType = "dailyCounter"
+	// Integral is a counter of the value integrated over time, like energy from power
+	Integral MetricType = "integral"
+)
+
+type IntegralOptions struct {
+	// MaxGap between polls up to which the value is integrated,
+	// longer gaps like when the inverter is unreachable are skipped
+	MaxGap time.Duration `yaml:"maxGap"`
+	// Split integrates positive and negative values into separate series by IntegralDirectionLabel,
+	// required for signed values, as a counter cannot decrease
+	Split bool `yaml:"split"`
+}
+
+const defaultIntegralMaxGap = time.Minute
+
+// IsSplit is false by default
+func (o *IntegralOptions) IsSplit() bool {
+	return o != nil && o.Split
+}
+
+// IntegralDirectionLabel distinguishes the integrals of the positive and negative values
+// of split integrals, like energy imported and exported
+const IntegralDirectionLabel = "direction"
+
+const (
+	IntegralPositive = "positive"
+	IntegralNegative = "negative"
+)
+
+// GetMaxGap defaults to one minute
+func (o *IntegralOptions) GetMaxGap() time.Duration {
+	if o == nil || o.MaxGap <= 0 {
+		return defaultIntegralMaxGap
+	}
+	return o.MaxGap
+}
+
+type DailyCounterOptions struct {
+	Mode DailyCounterMode `yaml:"mode"`
+	// Clock is the datetime register which determines midnight
+	Clock string `yaml:"clock"`
+}
+
+type DailyCounterMode string
+
+const (
+	// DailyCounterGauge exports the value as gauge with the timestamp of the last reset
+	DailyCounterGauge DailyCounterMode = "gauge"
+	// DailyCounterTotal exports a monotonic counter accumulating the daily values read by the poller
+	DailyCounterTotal DailyCounterMode = "total"
+)
+
+func (c *DailyCounterOptions) check(registers Registers) error {
+	if c.Mode != DailyCounterGauge && c.Mode != DailyCounterTotal {
+		return fmt.Errorf("unknown dailyCounter mode '%s'", c.Mode)
+	}
+	return checkClock(registers, c.Clock)
+}
+
+func checkClock(registers Registers, clock string) error {
+	registerConfig, ok := registers[clock]
+	if !ok {
+		return fmt.Errorf("unknown clock register '%s'", clock)
+	}
+	if registerConfig.Type != DateTimeRegisterType {
+		return fmt.Errorf("clock register %s is not a datetime register", clock)
+	}
+	return nil
+}
+
+type IndexLabel struct {
+	// Name defaults to idx
+	Name   string      `yaml:"name"`
+	Format IndexFormat `yaml:"format"`
+	// Offset is added to the index, like for arrays starting at another month or year
+	Offset int `yaml:"offset"`
+	// Clock is the datetime register which determines the year of format year
+	Clock string `yaml:"clock"`
+	// Skip omits the elements for which the expression is true
+	Skip *IndexSkip `yaml:"skip"`
+}
+
+type IndexFormat string
+
+const (
+	// IndexNumber is the index with two digits, like 05
+	IndexNumber IndexFormat = "number"
+	// IndexMonth is the month name, index 0 is January
+	IndexMonth IndexFormat = "month"
+	// IndexDay is the day of month with two digits, index 0 is 01
+	IndexDay IndexFormat = "day"
+	// IndexSlot is the start of the time slot as HH:MM, the slots split the day evenly
+	IndexSlot IndexFormat = "slot"
+	// IndexYear is the year of the clock plus the index
+	IndexYear IndexFormat = "year"
 )
 
+func (f *IndexFormat) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalEnum(node, f, IndexNumber, IndexMonth, IndexDay, IndexSlot, IndexYear)
+}
+
+func (l *IndexLabel) check(registers Registers, length int) error {
+	if l.Format == IndexSlot && (length > 24*60 || 24*60%length != 0) {
+		return fmt.Errorf("slot format requires a length dividing the day into minutes, got %d", length)
+	}
+	if l.Format == IndexYear {
+		return checkClock(registers, l.Clock)
+	}
+	if len(l.Clock) > 0 {
+		return errors.New("clock is only allowed for format year")
+	}
+	return nil
+}
+
+func (l *IndexLabel) GetName() string {
+	if l == nil || len(l.Name) == 0 {
+		return "idx"
+	}
+	return l.Name
+}
+
+// NeedsClock is true if the label values depend on the year of the clock
+func (l *IndexLabel) NeedsClock() bool {
+	return l != nil && l.Format == IndexYear
+}
+
+// LabelValue formats the index of an array of the given length,
+// the clock is only used by format year
+func (l *IndexLabel) LabelValue(index int, length int, clock time.Time) string {
+	if l == nil {
+		return fmt.Sprintf("%02d", index)
+	}
+	index += l.Offset
+	switch l.Format {
+	case IndexMonth:
+		return time.Month((index%12+12)%12 + 1).String()
+	case IndexDay:
+		return fmt.Sprintf("%02d", index+1)
+	case IndexSlot:
+		minutes := index * 24 * 60 / length
+		return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+	case IndexYear:
+		return fmt.Sprintf("%d", clock.Year()+index)
+	}
+	return fmt.Sprintf("%02d", index)
+}
+
+// Skips evaluates the skip expression of the element
+func (l *IndexLabel) Skips(provider RegisterValueProvider, index int, label string, value float64) (bool, error) {
+	if l == nil || l.Skip == nil {
+		return false, nil
+	}
+	return l.Skip.evaluate(provider, index, label, value)
+}
+
+// IndexSkip is an expression with the variables idx, label and value of the element,
+// like value == 0 for empty elements or idx > localSlot(15) for future time slots
+type IndexSkip struct {
+	registerFunc
+}
+
+func (s *IndexSkip) UnmarshalYAML(node *yaml.Node) error {
+	input := ""
+	if err := node.Decode(&input); err != nil {
+		return err
+	}
+	regFunc, err := newRegisterFunc(input, []*util.EnvEntry{util.Env("idx", 0), util.Env("label", ""), util.Env("value", 0.0)}, expr.AsBool())
+	if err != nil {
+		return nodeError(node, err)
+	}
+	s.registerFunc = *regFunc
+	return nil
+}
+
+func (s *IndexSkip) evaluate(provider RegisterValueProvider, index int, label string, value float64) (bool, error) {
+	result, err := s.registerFunc.evaluate(provider, map[string]interface{}{"idx": index, "label": label, "value": value})
+	if err != nil {
+		return false, err
+	}
+	return util.CastToBool(result), nil
+}
+
+type InfoRegister struct {
+	Name string `yaml:"name"`
+	// Label defaults to the register name without prefix, see TrimRegisterPrefix
+	Label string `yaml:"label"`
+}
+
+// UnmarshalYAML also accepts just the register name
+func (r *InfoRegister) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*r = InfoRegister{Name: node.Value}
+	} else {
+		type plain InfoRegister
+		if err := node.Decode((*plain)(r)); err != nil {
+			return err
+		}
+	}
+	if len(r.Name) == 0 {
+		return typeError("info register needs a name")
+	}
+	if len(r.Label) == 0 {
+		r.Label = TrimRegisterPrefix(r.Name)
+	}
+	return nil
+}
+
+var registerNamePrefix = regexp.MustCompile(`^[A-Z]\d+_`)
+
+// TrimRegisterPrefix strips the prefix of register names like R006_serial_number
+func TrimRegisterPrefix(registerName string) string {
+	return registerNamePrefix.ReplaceAllString(registerName, "")
+}
+
 type Label struct {
 	Name  string `yaml:"name"`
 	Value *Value `yaml:"value"`
@@ -38,31 +501,207 @@ type Label struct {
 type Value struct {
 	FromExpression *ExpressionValue `yaml:"fromExpression"`
 	FromRegister   *RegisterValue   `yaml:"fromRegister"`
+	Static         *string          `yaml:"static"`
 }
 
+// UnmarshalYAML also accepts a static value as plain string
+func (v *Value) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = Value{Static: &node.Value}
+		return nil
+	}
+	type plain Value
+	return node.Decode((*plain)(v))
+}
+
+// RegisterValue selects a single element of array registers
+// by a fixed index or an index computed on each read
 type RegisterValue struct {
-	Name string
+	Name                string           `yaml:"name"`
+	Index               *uint16          `yaml:"index"`
+	IndexFromExpression *ExpressionValue `yaml:"indexFromExpression"`
 }
 
+// UnmarshalYAML also accepts just the register name
 func (v *RegisterValue) UnmarshalYAML(node *yaml.Node) error {
-	s := ""
-	err := node.Decode(&s)
+	if node.Kind == yaml.ScalarNode {
+		*v = RegisterValue{Name: node.Value}
+		return nil
+	}
+	type plain RegisterValue
+	err := node.Decode((*plain)(v))
 	if err != nil {
 		return err
 	}
-	*v = RegisterValue{s}
+	if len(v.Name) == 0 {
+		return typeError("register value needs a name")
+	}
+	if v.Index != nil && v.IndexFromExpression != nil {
+		return typeError("register value %s cannot have both index and indexFromExpression", v.Name)
+	}
+	if v.IndexFromExpression != nil {
+		// fractional indices are truncated, like for localHour()*4 + localMinute()/15
+		return v.IndexFromExpression.expect(expr.AsInt64())
+	}
+	return nil
+}
+
+// HasIndex is true if a single element is selected
+func (v *RegisterValue) HasIndex() bool {
+	return v.Index != nil || v.IndexFromExpression != nil
+}
+
+func (v *RegisterValue) GetIndex(provider RegisterValueProvider) (uint16, error) {
+	if v.IndexFromExpression != nil {
+		result, err := v.IndexFromExpression.Evaluate(provider)
+		if err != nil {
+			return 0, err
+		}
+		index, err := toIndex(result)
+		if err != nil {
+			return 0, err
+		}
+		if index < 0 || index > math.MaxUint16 {
+			return 0, fmt.Errorf("index %d of register %s out of range", index, v.Name)
+		}
+		return uint16(index), nil
+	}
+	if v.Index != nil {
+		return *v.Index, nil
+	}
+	return 0, nil
+}
+
+// CheckPollInterval rejects poll intervals which are not shorter than the maxGap of an integral metric,
+// as every poll would be skipped as gap
+func (metrics Metrics) CheckPollInterval(interval time.Duration) error {
+	for _, metric := range metrics {
+		if metric.Type != Integral {
+			continue
+		}
+		if maxGap := metric.Integral.GetMaxGap(); interval >= maxGap {
+			return fmt.Errorf("poll interval %s must be shorter than maxGap %s of integral metric %s", interval, maxGap, metric.Name)
+		}
+	}
 	return nil
 }
 
 func (metrics Metrics) FindRegisterNames() []string {
 	var r []string
 	for _, metric := range metrics {
-		if registerValue := metric.Value.FromRegister; registerValue != nil {
-			r = append(r, registerValue.Name)
+		r = append(r, metric.Value.registerNames(metrics)...)
+		for _, label := range metric.Labels {
+			r = append(r, label.Value.registerNames(metrics)...)
+		}
+		for _, infoRegister := range metric.Registers {
+			r = append(r, infoRegister.Name)
 		}
-		if expressionValue := metric.Value.FromExpression; expressionValue != nil {
-			r = append(r, expressionValue.registerNames...)
+		if dailyCounter := metric.DailyCounter; dailyCounter != nil {
+			r = append(r, dailyCounter.Clock)
 		}
+		if indexLabel := metric.IndexLabel; indexLabel != nil {
+			if indexLabel.NeedsClock() {
+				r = append(r, indexLabel.Clock)
+			}
+			if indexLabel.Skip != nil {
+				r = append(r, indexLabel.Skip.allRegisterNames(metrics)...)
+			}
+		}
+	}
+	return r
+}
+
+// registerNames includes the registers of referenced metrics
+func (v *Value) registerNames(metrics Metrics) []string {
+	if v == nil {
+		return nil
+	}
+	var r []string
+	for _, f := range v.registerFuncs() {
+		r = append(r, f.allRegisterNames(metrics)...)
+	}
+	if registerValue := v.FromRegister; registerValue != nil {
+		r = append(r, registerValue.Name)
 	}
 	return r
 }
+
+func (v *Value) metricNames() []string {
+	if v == nil {
+		return nil
+	}
+	var r []string
+	for _, f := range v.registerFuncs() {
+		r = append(r, f.metricNames...)
+	}
+	return r
+}
+
+func (v *Value) registerFuncs() []*registerFunc {
+	if v == nil {
+		return nil
+	}
+	var r []*registerFunc
+	if registerValue := v.FromRegister; registerValue != nil && registerValue.IndexFromExpression != nil {
+		r = append(r, &registerValue.IndexFromExpression.registerFunc)
+	}
+	if expressionValue := v.FromExpression; expressionValue != nil {
+		r = append(r, &expressionValue.registerFunc)
+	}
+	return r
+}
+
+// UsesWindowFunctions is true if the value needs to be recorded by the poller
+func (v *Value) UsesWindowFunctions() bool {
+	if v == nil {
+		return false
+	}
+	for _, f := range v.registerFuncs() {
+		if len(f.windows.calls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Record evaluates the value expression, recording the values of window functions,
+// the interval between records determines the number of values kept
+func (v *Value) Record(provider RegisterValueProvider, now time.Time, interval time.Duration) error {
+	if v == nil || v.FromExpression == nil {
+		return nil
+	}
+	return v.FromExpression.record(provider, now, interval)
+}
+
+// evaluateFloat64 fails for array registers without index
+func (v *Value) evaluateFloat64(provider RegisterValueProvider) (float64, error) {
+	if v == nil {
+		return 0, errors.New("metric has no value")
+	}
+	if registerValue := v.FromRegister; registerValue != nil {
+		values := provider(registerValue.Name)
+		if len(values) > 1 && !registerValue.HasIndex() {
+			return 0, fmt.Errorf("register %s is an array, index required", registerValue.Name)
+		}
+		index, err := registerValue.GetIndex(provider)
+		if err != nil {
+			return 0, err
+		}
+		if int(index) >= len(values) {
+			return 0, fmt.Errorf("index %d out of range of register %s with length %d", index, registerValue.Name, len(values))
+		}
+		return values[index], nil
+	}
+	if expressionValue := v.FromExpression; expressionValue != nil {
+		result, err := expressionValue.Evaluate(provider)
+		if err != nil {
+			return 0, err
+		}
+		switch result.(type) {
+		case float64, int, int64:
+			return util.NumericToFloat64(result), nil
+		}
+		return 0, fmt.Errorf("expression result %v is not numeric", result)
+	}
+	return 0, errors.New("value has neither register nor expression")
+}
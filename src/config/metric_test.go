@@ -0,0 +1,252 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+	"time"
+)
+
+func TestRegisterValue_UnmarshalYAML(t *testing.T) {
+	provider := func(registerName string) []float64 {
+		return []float64{7}
+	}
+	tests := []struct {
+		input string
+		name  string
+		index uint16
+		err   string
+	}{
+		{"R034_daily_pv_yields", "R034_daily_pv_yields", 0, ""},
+		{"{name: R034_daily_pv_yields, index: 3}", "R034_daily_pv_yields", 3, ""},
+		{"{name: R034_daily_pv_yields, indexFromExpression: register('day') - 1}", "R034_daily_pv_yields", 6, ""},
+		{"{index: 3}", "", 0, "register value needs a name"},
+		{"{name: R034_daily_pv_yields, index: 3, indexFromExpression: 1}", "", 0, "cannot have both index and indexFromExpression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var v RegisterValue
+			err := yaml.Unmarshal([]byte(tt.input), &v)
+			if len(tt.err) > 0 {
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.name, v.Name)
+			index, err := v.GetIndex(provider)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.index, index)
+		})
+	}
+}
+
+func TestMetrics_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"series map", `
+- name: power
+  unit: watt
+  seriesLabel: phase
+  value:
+    fromExpression: "{'a': 1, 'b': 2.5}"
+  labels:
+    - name: source
+      value: inverter`, ""},
+		{"series without map", `
+- name: power
+  seriesLabel: phase
+  value:
+    fromExpression: 1`, "expected map, but got int"},
+		{"series without expression", `
+- name: power
+  seriesLabel: phase
+  value:
+    fromRegister: power`, "seriesLabel requires value fromExpression"},
+		{"static value", `
+- name: power
+  value: 1`, "static value is only allowed for labels"},
+		{"duplicate name", `
+- name: power
+  value:
+    fromRegister: power
+- name: power
+  value:
+    fromRegister: power`, "line 5: duplicate name power, first defined in line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := unmarshalMetrics(tt.input, nil)
+			if len(tt.err) > 0 {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "watt", metrics["power"].Unit)
+			assert.Equal(t, "inverter", *metrics["power"].Labels[0].Value.Static)
+			result, err := metrics["power"].Value.FromExpression.Evaluate(nil)
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"a": 1, "b": 2.5}, result)
+		})
+	}
+}
+
+// metricTestRegisters are shared by the tests of metric types
+var metricTestRegisters = Registers{
+	"state":   &Register{Name: "state", MapValue: RegisterMapValue{ByEnumMap: map[int64]string{0xAA: "off_grid", 0x55: "on_grid"}}},
+	"power":   &Register{Name: "power", Type: S32RegisterType, Unit: "watt"},
+	"load":    &Register{Name: "load", Type: U32RegisterType, Unit: "watt"},
+	"voltage": &Register{Name: "voltage", Type: U16RegisterType, Unit: "volt"},
+	"energy":  &Register{Name: "energy", Type: U16RegisterType},
+	"clock":   &Register{Name: "clock", Type: DateTimeRegisterType},
+	"monthly": &Register{Name: "monthly", Type: U16RegisterType, Length: 12},
+	"weekly":  &Register{Name: "weekly", Type: U16RegisterType, Length: 7},
+	"yearly":  &Register{Name: "yearly", Type: U32RegisterType, Length: 20},
+}
+
+func TestMetrics_UnmarshalYAML_Types(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		registerNames []string
+		err           string
+	}{
+		// stateset
+		{"stateset of enum register", "[{name: state, type: stateset, value: {fromRegister: state}}]", []string{"state"}, ""},
+		{"stateset without enum", "[{name: state, type: stateset, value: {fromRegister: power}}]", nil, "register power has no enum mapValue"},
+		{"stateset with index", "[{name: state, type: stateset, value: {fromRegister: {name: state, index: 1}}}]", nil, "does not support index"},
+		{"stateset of expression", "[{name: state, type: stateset, value: {fromExpression: 1}}]", nil, "stateset requires value fromRegister"},
+		// info
+		{"info with default labels", "[{name: device, type: info, registers: [R006_serial_number, W01_x_y]}]", []string{"R006_serial_number", "W01_x_y"}, ""},
+		{"info with explicit label", "[{name: device, type: info, registers: [{name: R006_serial_number, label: sn}, other]}]", []string{"R006_serial_number", "other"}, ""},
+		{"info with duplicate label", "[{name: device, type: info, registers: [R006_sn, {name: other, label: sn}]}]", nil, "duplicate label sn"},
+		{"info with value", "[{name: device, type: info, value: {fromExpression: 1}, registers: [sn]}]", nil, "info metric cannot have a value"},
+		{"info without registers", "[{name: device, type: info}]", nil, "info metric requires registers"},
+		{"registers for gauge", "[{name: device, value: {fromRegister: sn}, registers: [sn]}]", nil, "registers are only allowed for info metrics"},
+		// dailyCounter
+		{"dailyCounter gauge", "[{name: energy, type: dailyCounter, dailyCounter: {mode: gauge, clock: clock}, value: {fromRegister: energy}}]", []string{"energy", "clock"}, ""},
+		{"dailyCounter total", "[{name: energy, type: dailyCounter, dailyCounter: {mode: total, clock: clock}, value: {fromRegister: energy}}]", []string{"energy", "clock"}, ""},
+		{"dailyCounter of unknown mode", "[{name: energy, type: dailyCounter, dailyCounter: {mode: daily, clock: clock}, value: {fromRegister: energy}}]", nil, "unknown dailyCounter mode 'daily'"},
+		{"dailyCounter clock not datetime", "[{name: energy, type: dailyCounter, dailyCounter: {mode: gauge, clock: energy}, value: {fromRegister: energy}}]", nil, "clock register energy is not a datetime register"},
+		{"dailyCounter of unknown clock", "[{name: energy, type: dailyCounter, dailyCounter: {mode: gauge, clock: missing}, value: {fromRegister: energy}}]", nil, "unknown clock register 'missing'"},
+		{"dailyCounter without options", "[{name: energy, type: dailyCounter, value: {fromRegister: energy}}]", nil, "dailyCounter is required"},
+		{"dailyCounter options for counter", "[{name: energy, type: counter, dailyCounter: {mode: gauge, clock: clock}, value: {fromRegister: energy}}]", nil, "only allowed for type dailyCounter"},
+		// integral
+		{"integral from register", "[{name: energy, type: integral, integral: {split: true}, value: {fromRegister: power}}]", []string{"power"}, ""},
+		{"integral without split", "[{name: energy, type: integral, value: {fromRegister: load}}]", []string{"load"}, ""},
+		{"integral with output unit", "[{name: energy, type: integral, outputUnit: kilowatthour, integral: {maxGap: 2m, split: true}, value: {fromRegister: power}}]", []string{"power"}, ""},
+		{"integral from expression", "[{name: energy, type: integral, unit: kilowatt, value: {fromExpression: 2 * register('power')}}]", []string{"power"}, ""},
+		{"integral without unit", "[{name: energy, type: integral, value: {fromExpression: 1}}]", nil, "integral requires a unit"},
+		{"integral not of power", "[{name: energy, type: integral, value: {fromRegister: voltage}}]", nil, "cannot integrate volt of voltage"},
+		{"integral with output unit of power", "[{name: energy, type: integral, outputUnit: kilowatt, value: {fromRegister: load}}]", nil, "cannot convert joule of energy to kilowatt of power"},
+		{"integral options for gauge", "[{name: energy, type: gauge, integral: {maxGap: 2m}, value: {fromRegister: power}}]", nil, "only allowed for type integral"},
+		{"integral with direction label", "[{name: energy, type: integral, integral: {split: true}, labels: [{name: direction, value: import}], value: {fromRegister: power}}]", nil, "duplicate label direction"},
+		{"integral without split with direction label", "[{name: energy, type: integral, labels: [{name: direction, value: import}], value: {fromRegister: load}}]", []string{"load"}, ""},
+		// windows
+		{"declared windows", "[{name: power, windows: [1m, 5m], value: {fromExpression: \"avg_over(register('power'), '5m')\"}}]", []string{"power"}, ""},
+		{"since midnight", "[{name: power, value: {fromExpression: \"max_since_midnight(register('power'))\"}}]", []string{"power"}, ""},
+		{"window not declared", "[{name: power, windows: [1m], value: {fromExpression: \"avg_over(register('power'), '5m')\"}}]", nil, "window 5m0s of avg_over is not declared in windows"},
+		{"window in label", "[{name: power, labels: [{name: avg, value: {fromExpression: \"avg_over(register('power'), '5m')\"}}], value: {fromRegister: power}}]", nil, "window functions are only allowed in the value of the metric"},
+		{"window in index", "[{name: power, value: {fromRegister: {name: power, indexFromExpression: \"delta(register('power'), '5m')\"}}}]", nil, "window functions are not allowed in indexFromExpression"},
+		{"windows without expression", "[{name: power, windows: [5m], value: {fromRegister: power}}]", nil, "windows require value fromExpression"},
+		// indexLabel
+		{"indexLabel of month", "[{name: yields, indexLabel: {name: month, format: month, skip: 'idx >= localMonth()'}, value: {fromRegister: monthly}}]", []string{"monthly"}, ""},
+		{"indexLabel of year", "[{name: yields, indexLabel: {format: year, offset: -19, clock: clock}, value: {fromRegister: yearly}}]", []string{"yearly", "clock"}, ""},
+		{"indexLabel of unknown format", "[{name: yields, indexLabel: {format: week}, value: {fromRegister: monthly}}]", nil, "unknown value 'week'"},
+		{"indexLabel skip not bool", "[{name: yields, indexLabel: {skip: 'value + 1'}, value: {fromRegister: monthly}}]", nil, "cannot compile 'value + 1'"},
+		{"indexLabel not of an array", "[{name: yields, indexLabel: {format: day}, value: {fromRegister: clock}}]", nil, "indexLabel requires an array register, clock is none"},
+		{"indexLabel with index", "[{name: yields, indexLabel: {format: day}, value: {fromRegister: {name: monthly, index: 1}}}]", nil, "indexLabel requires value fromRegister without index"},
+		{"indexLabel slot of weekdays", "[{name: yields, indexLabel: {format: slot}, value: {fromRegister: weekly}}]", nil, "slot format requires a length dividing the day into minutes, got 7"},
+		{"indexLabel year without clock", "[{name: yields, indexLabel: {format: year}, value: {fromRegister: yearly}}]", nil, "unknown clock register ''"},
+		{"indexLabel clock of month", "[{name: yields, indexLabel: {format: month, clock: clock}, value: {fromRegister: monthly}}]", nil, "clock is only allowed for format year"},
+		{"indexLabel duplicate label", "[{name: yields, labels: [{name: idx, value: a}], indexLabel: {}, value: {fromRegister: monthly}}]", nil, "duplicate label idx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := unmarshalMetrics(tt.input, metricTestRegisters)
+			if len(tt.err) > 0 {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.registerNames, metrics.FindRegisterNames())
+		})
+	}
+}
+
+func TestMetrics_CheckPollInterval(t *testing.T) {
+	metrics, err := unmarshalMetrics(`
+- name: energy
+  type: integral
+  integral:
+    maxGap: 2m
+  value:
+    fromRegister: load
+- name: power
+  value:
+    fromRegister: load`, metricTestRegisters)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, metrics.CheckPollInterval(time.Minute))
+	assert.EqualError(t, metrics.CheckPollInterval(2*time.Minute), "poll interval 2m0s must be shorter than maxGap 2m0s of integral metric energy")
+}
+
+func TestMetrics_UnmarshalYAML_InfoLabels(t *testing.T) {
+	metrics, err := unmarshalMetrics("[{name: device, type: info, registers: [{name: R006_serial_number, label: sn}, W01_x_y]}]", metricTestRegisters)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var labels []string
+	for _, infoRegister := range metrics["device"].Registers {
+		labels = append(labels, infoRegister.Label)
+	}
+	assert.Equal(t, []string{"sn", "x_y"}, labels)
+}
+
+func TestIndexLabel_LabelValue(t *testing.T) {
+	clock := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		indexLabel *IndexLabel
+		index      int
+		length     int
+		expected   string
+	}{
+		{nil, 3, 12, "03"},
+		{&IndexLabel{}, 3, 12, "03"},
+		{&IndexLabel{Format: IndexNumber, Offset: 1}, 3, 12, "04"},
+		{&IndexLabel{Format: IndexMonth}, 0, 12, "January"},
+		{&IndexLabel{Format: IndexMonth, Offset: -1}, 0, 12, "December"},
+		{&IndexLabel{Format: IndexDay}, 30, 31, "31"},
+		{&IndexLabel{Format: IndexSlot}, 37, 96, "09:15"},
+		{&IndexLabel{Format: IndexSlot}, 23, 24, "23:00"},
+		{&IndexLabel{Format: IndexYear, Offset: -19}, 19, 20, "2022"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.indexLabel.LabelValue(tt.index, tt.length, clock))
+		})
+	}
+}
+
+func TestIndexLabel_Skips(t *testing.T) {
+	var indexLabel IndexLabel
+	assert.NoError(t, yaml.Unmarshal([]byte("{format: month, skip: \"value == 0 || label == 'March'\"}"), &indexLabel))
+	for _, tt := range []struct {
+		index    int
+		value    float64
+		expected bool
+	}{
+		{0, 1, false},
+		{1, 0, true},
+		{2, 1, true},
+	} {
+		skip, err := indexLabel.Skips(nil, tt.index, indexLabel.LabelValue(tt.index, 12, time.Time{}), tt.value)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, skip)
+	}
+}
@@ -14,11 +14,11 @@ type Config struct {
 
 func Read() (*Config, error) {
 	configDir := getConfigDir()
-	metrics, err := unmarshalFromFile[Metrics](path.Join(configDir, "metrics.yaml"))
+	registers, err := unmarshalFromFile[Registers](path.Join(configDir, "registers.yaml"))
 	if err != nil {
 		return nil, err
 	}
-	registers, err := unmarshalFromFile[Registers](path.Join(configDir, "registers.yaml"))
+	metrics, err := unmarshalFromFile[Metrics](path.Join(configDir, "metrics.yaml"))
 	if err != nil {
 		return nil, err
 	}
@@ -26,7 +26,24 @@ func Read() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Config{*metrics, *registers, *actuators}, nil
+	config := &Config{*metrics, *registers, *actuators}
+	if err := config.bind(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// bind lets expressions refer to registers and metrics by name,
+// which requires all configs to be read
+func (c *Config) bind() error {
+	refs := &references{c.Registers, c.Metrics}
+	if err := c.Registers.bind(refs); err != nil {
+		return err
+	}
+	if err := c.Metrics.bind(refs); err != nil {
+		return err
+	}
+	return c.Actuators.bind(refs)
 }
 
 func getConfigDir() string {
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"sungrow-prometheus-exporter/src/util"
+)
+
+// references are the configs expressions can refer to by name,
+// bound to the expressions once all configs are read, see Config.bind
+type references struct {
+	registers Registers
+	metrics   Metrics
+}
+
+// referenceEnv complements the stdlib of util.BuildEnv,
+// the references are nil while type checking
+func referenceEnv(refs *references) []*util.EnvEntry {
+	return []*util.EnvEntry{
+		util.Env("enumName", func(registerName string, value interface{}) (string, error) {
+			enumMap, err := refs.findEnumMap(registerName)
+			if err != nil {
+				return "", err
+			}
+			switch value.(type) {
+			case float64, int, int64:
+			default:
+				return "", fmt.Errorf("enum value %v of register %s is not numeric", value, registerName)
+			}
+			rawValue := int64(util.NumericToFloat64(value))
+			if name, ok := enumMap[rawValue]; ok {
+				return name, nil
+			}
+			return fmt.Sprintf("%v", rawValue), nil
+		}),
+		util.Env("enumValue", func(registerName string, name string) (float64, error) {
+			enumMap, err := refs.findEnumMap(registerName)
+			if err != nil {
+				return 0, err
+			}
+			if rawValue := util.GetMapKeyForValue(enumMap, name); rawValue != nil {
+				return float64(*rawValue), nil
+			}
+			return 0, fmt.Errorf("cannot find value %s in %v", name, util.GetValues(enumMap))
+		}),
+	}
+}
+
+func (refs *references) findEnumMap(registerName string) (map[int64]string, error) {
+	if refs == nil || refs.registers == nil {
+		return nil, fmt.Errorf("cannot access enum of register %s before registers are read", registerName)
+	}
+	return refs.registers.findEnumMap(registerName)
+}
+
+func (registers Registers) findEnumMap(registerName string) (map[int64]string, error) {
+	registerConfig, ok := registers[registerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown register '%s'", registerName)
+	}
+	if registerConfig.MapValue.ByEnumMap == nil {
+		return nil, fmt.Errorf("register %s has no enum mapValue", registerName)
+	}
+	return registerConfig.MapValue.ByEnumMap, nil
+}
+
+func (refs *references) metricValue(metricName string, provider RegisterValueProvider) (float64, error) {
+	if refs == nil {
+		return 0, fmt.Errorf("cannot access metric %s before metrics are read", metricName)
+	}
+	metric, ok := refs.metrics[metricName]
+	if !ok {
+		return 0, fmt.Errorf("unknown metric '%s'", metricName)
+	}
+	return metric.Value.evaluateFloat64(provider)
+}
+
+// checkMetricReferences rejects unknown metrics and cyclic references
+func checkMetricReferences(metrics Metrics, metricNames []string, visiting map[string]bool) error {
+	for _, metricName := range metricNames {
+		metric, ok := metrics[metricName]
+		if !ok {
+			return fmt.Errorf("unknown metric '%s'", metricName)
+		}
+		if visiting[metricName] {
+			return fmt.Errorf("metric %s references itself", metricName)
+		}
+		visiting[metricName] = true
+		err := checkMetricReferences(metrics, metric.Value.metricNames(), visiting)
+		if err != nil {
+			return err
+		}
+		delete(visiting, metricName)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestReferences(t *testing.T) {
+	registers := Registers{"state": &Register{Name: "state", MapValue: RegisterMapValue{ByEnumMap: map[int64]string{0xAA: "off_grid", 0x55: "on_grid"}}}}
+	metrics, err := unmarshalMetrics(`
+- name: power
+  value:
+    fromRegister: power
+- name: double_power
+  value:
+    fromExpression: 2 * metric('power')
+- name: grid_state
+  value:
+    fromExpression: "enumName('state', register('state')) == 'on_grid' ? enumValue('state', 'on_grid') : metric('double_power')"
+`, registers)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	values := map[string][]float64{"power": {21}, "state": {0xAA}}
+	provider := func(registerName string) []float64 {
+		return values[registerName]
+	}
+	value, err := metrics["grid_state"].Value.evaluateFloat64(provider)
+	assert.NoError(t, err)
+	assert.Equal(t, 42.0, value)
+	values["state"] = []float64{0x55}
+	value, err = metrics["grid_state"].Value.evaluateFloat64(provider)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0x55), value)
+	assert.ElementsMatch(t, []string{"power", "state"}, uniqueStrings(metrics["grid_state"].Value.registerNames(metrics)))
+}
+
+func TestReferences_Invalid(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"[{name: a, value: {fromExpression: \"metric('b')\"}}]", "unknown metric 'b'"},
+		{"[{name: a, value: {fromExpression: \"metric('b')\"}}, {name: b, value: {fromExpression: \"metric('a')\"}}]", "references itself"},
+		{"[{name: a, value: {fromExpression: \"enumValue('missing', 'on')\"}}]", "unknown register 'missing'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := unmarshalMetrics(tt.input, Registers{})
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+// unmarshalMetrics binds the metrics to the registers, like Read
+func unmarshalMetrics(input string, registers Registers) (Metrics, error) {
+	var metrics Metrics
+	if err := yaml.Unmarshal([]byte(input), &metrics); err != nil {
+		return nil, err
+	}
+	config := &Config{Metrics: metrics, Registers: registers}
+	return metrics, config.bind()
+}
+
+func uniqueStrings(s []string) []string {
+	m := map[string]struct{}{}
+	for _, v := range s {
+		m[v] = struct{}{}
+	}
+	var r []string
+	for v := range m {
+		r = append(r, v)
+	}
+	return r
+}
@@ -2,43 +2,321 @@ package config
 
 import (
 	"fmt"
+	"github.com/antonmedv/expr"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
+	"math"
+	"strconv"
+	"strings"
 	"sungrow-prometheus-exporter/src/util"
+	"time"
 )
 
 type Registers map[string]*Register
 
+// bind the references to the expressions of the registers
+func (registers Registers) bind(refs *references) error {
+	for _, register := range registers {
+		if register.Validation != nil {
+			if err := register.Validation.regFunc.bind(refs); err != nil {
+				return fmt.Errorf("invalid register %s: %w", register.Name, err)
+			}
+		}
+		bindFunction(register.MapValue.refs, refs)
+		if register.Inverse != nil {
+			bindFunction(register.Inverse.refs, refs)
+		}
+	}
+	return nil
+}
+
 func (registers *Registers) UnmarshalYAML(node *yaml.Node) error {
-	return unmarshalNamedSequenceToMap[Register](node, (*map[string]*Register)(registers))
+	err := unmarshalNamedSequenceToMap[Register](node, (*map[string]*Register)(registers))
+	if err != nil {
+		return err
+	}
+	for _, register := range *registers {
+		if len(register.Unit) > 0 {
+			if _, err := GetUnit(register.Unit); err != nil {
+				return fmt.Errorf("invalid register %s: %w", register.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Location is the timezone of the datetime registers, that is of the inverter clock,
+// nil if there are no datetime registers
+func (registers Registers) Location() (*time.Location, error) {
+	var timezone *string
+	for _, register := range registers {
+		if register.Type != DateTimeRegisterType {
+			continue
+		}
+		registerTimezone := register.Timezone
+		if len(registerTimezone) == 0 {
+			// datetime registers default to UTC
+			registerTimezone = time.UTC.String()
+		}
+		if timezone != nil && *timezone != registerTimezone {
+			return nil, fmt.Errorf("datetime registers have different timezones %s and %s", *timezone, registerTimezone)
+		}
+		timezone = &registerTimezone
+	}
+	if timezone == nil {
+		return nil, nil
+	}
+	return time.LoadLocation(*timezone)
+}
+
+// SetTimezone overrides the timezone of the datetime registers,
+// such that the inverter clock and local time functions agree
+func (registers Registers) SetTimezone(timezone string) error {
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return err
+	}
+	for _, register := range registers {
+		if register.Type == DateTimeRegisterType {
+			register.Timezone = timezone
+		}
+	}
+	return nil
 }
 
 type Register struct {
-	Name       string             `yaml:"name"`
-	Type       RegisterType       `yaml:"type"`
-	Address    uint16             `yaml:"address"`
-	Writable   bool               `yaml:"writable"`
-	Validation RegisterValidation `yaml:"validation"`
-	Length     uint16             `yaml:"length"`
-	Unit       string             `yaml:"unit"`
-	MapValue   RegisterMapValue   `yaml:"mapValue"`
+	Name           string              `yaml:"name"`
+	Type           RegisterType        `yaml:"type"`
+	Address        uint16              `yaml:"address"`
+	Writable       bool                `yaml:"writable"`
+	Validation     *RegisterValidation `yaml:"validation"`
+	Length         uint16              `yaml:"length"`
+	Unit           string              `yaml:"unit"`
+	MapValue       RegisterMapValue    `yaml:"mapValue"`
+	Words          uint16              `yaml:"words"`
+	Flags          []*RegisterFlag     `yaml:"flags"`
+	Fields         []DateTimeField     `yaml:"fields"`
+	Timezone       string              `yaml:"timezone"`
+	String         StringOptions       `yaml:",inline"`
+	Rounding       RoundingMode        `yaml:"rounding"`
+	Table          RegisterTable       `yaml:"table"`
+	WriteFunction  WriteFunction       `yaml:"writeFunction"`
+	UnitID         uint8               `yaml:"unitId"`
+	InvalidValues  []int64             `yaml:"invalidValues"`
+	PlausibleRange *ValueRange         `yaml:"plausibleRange"`
+	Scale          *float64            `yaml:"scale"`
+	Offset         float64             `yaml:"offset"`
+	Decimals       *int                `yaml:"decimals"`
+	Inverse        *RegisterInverse    `yaml:"inverse"`
 }
 
 func (m Register) GetKey() string {
 	return m.Name
 }
 
+// GetTable defaults to holding registers for writable registers
+// and to input registers otherwise
+func (m Register) GetTable() RegisterTable {
+	if len(m.Table) > 0 {
+		return m.Table
+	}
+	if m.Writable {
+		return HoldingRegisterTable
+	}
+	return InputRegisterTable
+}
+
+// GetSpace returns the address space of the register, see RegisterSpace
+func (m Register) GetSpace() RegisterSpace {
+	return RegisterSpace{m.UnitID, m.GetTable()}
+}
+
+// RegisterSpace identifies the address space a register lives in,
+// addresses are only unique within the same space.
+// UnitID 0 refers to the default unit of the connection.
+type RegisterSpace struct {
+	UnitID uint8
+	Table  RegisterTable
+}
+
+func (s RegisterSpace) String() string {
+	return fmt.Sprintf("%d/%s", s.UnitID, s.Table)
+}
+
+type RegisterTable string
+
+const (
+	InputRegisterTable   RegisterTable = "input"
+	HoldingRegisterTable RegisterTable = "holding"
+)
+
+func (t *RegisterTable) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalEnum(node, t, InputRegisterTable, HoldingRegisterTable)
+}
+
+type WriteFunction string
+
+const (
+	// WriteMultipleFunction uses Modbus function code 16
+	WriteMultipleFunction WriteFunction = "multiple"
+	// WriteSingleFunction uses Modbus function code 6, only for registers of one word
+	WriteSingleFunction WriteFunction = "single"
+)
+
+func (f *WriteFunction) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalEnum(node, f, WriteMultipleFunction, WriteSingleFunction)
+}
+
 type RegisterType string
 
 const (
-	U16RegisterType    RegisterType = "u16"
-	U32RegisterType    RegisterType = "u32"
-	S16RegisterType    RegisterType = "s16"
-	S32RegisterType    RegisterType = "s32"
-	StringRegisterType RegisterType = "string"
+	U16RegisterType      RegisterType = "u16"
+	U32RegisterType      RegisterType = "u32"
+	S16RegisterType      RegisterType = "s16"
+	S32RegisterType      RegisterType = "s32"
+	StringRegisterType   RegisterType = "string"
+	BitfieldRegisterType RegisterType = "bitfield"
+	DateTimeRegisterType RegisterType = "datetime"
+)
+
+const flagSeparator = "."
+
+// SplitRegisterName splits names like 'R051_running_state.battery_charging'
+// into the register name and the name of the flag within a bitfield register.
+func SplitRegisterName(name string) (registerName, flagName string) {
+	registerName, flagName, _ = strings.Cut(name, flagSeparator)
+	return
+}
+
+func JoinRegisterName(registerName, flagName string) string {
+	return registerName + flagSeparator + flagName
+}
+
+type RegisterFlag struct {
+	Name string   `yaml:"name"`
+	Bits BitRange `yaml:"bits"`
+}
+
+func (f RegisterFlag) GetKey() string {
+	return f.Name
+}
+
+type BitRange struct {
+	Low, High uint16
+}
+
+func (b *BitRange) UnmarshalYAML(node *yaml.Node) error {
+	s := ""
+	err := node.Decode(&s)
+	if err != nil {
+		return err
+	}
+	low, high, isRange := strings.Cut(s, "-")
+	lowBit, err := strconv.ParseUint(strings.TrimSpace(low), 0, 8)
+	if err != nil {
+		return typeError("cannot parse bits '%s'", s)
+	}
+	highBit := lowBit
+	if isRange {
+		highBit, err = strconv.ParseUint(strings.TrimSpace(high), 0, 8)
+		if err != nil {
+			return typeError("cannot parse bits '%s'", s)
+		}
+	}
+	if lowBit > highBit {
+		return typeError("bits '%s' must be ordered from low to high", s)
+	}
+	*b = BitRange{uint16(lowBit), uint16(highBit)}
+	return nil
+}
+
+func (b BitRange) Width() uint16 {
+	return b.High - b.Low + 1
+}
+
+type StringOptions struct {
+	Encoding    StringEncoding `yaml:"encoding"`
+	ByteSwapped bool           `yaml:"byteSwapped"`
+	Padding     StringPadding  `yaml:"padding"`
+	TrimSpace   bool           `yaml:"trimSpace"`
+}
+
+type StringEncoding string
+
+const (
+	// ASCIIStringEncoding stores two characters per word, high byte first
+	ASCIIStringEncoding StringEncoding = "ascii"
+	// UTF16StringEncoding stores one UTF-16 code unit per word
+	UTF16StringEncoding StringEncoding = "utf16"
 )
 
-type RegisterValidation func(value float64, provider RegisterValueProvider) error
+func (e *StringEncoding) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalEnum(node, e, ASCIIStringEncoding, UTF16StringEncoding)
+}
+
+type StringPadding string
+
+const (
+	NulStringPadding   StringPadding = "nul"
+	SpaceStringPadding StringPadding = "space"
+)
+
+func (p *StringPadding) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalEnum(node, p, NulStringPadding, SpaceStringPadding)
+}
+
+// ValueRange is inclusive, a missing bound is not checked
+type ValueRange struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+func (r ValueRange) Contains(value float64) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	return (r.Min == nil || value >= *r.Min) && (r.Max == nil || value <= *r.Max)
+}
+
+// RoundingMode is applied when writing values into integer registers
+type RoundingMode string
+
+const (
+	RoundNearest  RoundingMode = "nearest"
+	RoundHalfEven RoundingMode = "halfEven"
+	RoundDown     RoundingMode = "down"
+	RoundUp       RoundingMode = "up"
+	RoundTruncate RoundingMode = "truncate"
+)
+
+func (m *RoundingMode) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalEnum(node, m, RoundNearest, RoundHalfEven, RoundDown, RoundUp, RoundTruncate)
+}
+
+// DateTimeField describes the content of one word of a datetime register
+type DateTimeField string
+
+const (
+	YearField     DateTimeField = "year"
+	MonthField    DateTimeField = "month"
+	DayField      DateTimeField = "day"
+	HourField     DateTimeField = "hour"
+	MinuteField   DateTimeField = "minute"
+	SecondField   DateTimeField = "second"
+	ReservedField DateTimeField = "reserved"
+)
+
+var DefaultDateTimeFields = []DateTimeField{YearField, MonthField, DayField, HourField, MinuteField, SecondField}
+
+func (f *DateTimeField) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalEnum(node, f, YearField, MonthField, DayField, HourField, MinuteField, SecondField, ReservedField)
+}
+
+// RegisterValidation is an expression of the value to write,
+// like {timestamp: 'timestamp > 946684800'}
+type RegisterValidation struct {
+	varName string
+	regFunc *registerFunc
+}
 
 func (validation *RegisterValidation) UnmarshalYAML(node *yaml.Node) error {
 	m := map[string]string{}
@@ -47,28 +325,51 @@ func (validation *RegisterValidation) UnmarshalYAML(node *yaml.Node) error {
 		return err
 	}
 	*validation, err = expectOneElementMap(m, func(varName, expression string) (RegisterValidation, error) {
-		return func(value float64, provider RegisterValueProvider) error {
-			regFunc, err := newRegisterFunc(expression, util.Env(varName, value))
-			if err != nil {
-				return err
-			}
-			valid, err := regFunc.evaluate(provider)
-			if err != nil {
-				return err
-			}
-			if !util.CastToBool(valid) {
-				return fmt.Errorf("invalid value '%f'", value)
-			}
-			return nil
-		}, nil
+		// compile once, the value is only known when validating
+		regFunc, err := newRegisterFunc(expression, []*util.EnvEntry{util.Env(varName, 0.0)}, expr.AsBool())
+		if err != nil {
+			return RegisterValidation{}, err
+		}
+		return RegisterValidation{varName, regFunc}, nil
 	})
-	return err
+	return nodeError(node, err)
+}
+
+func (validation *RegisterValidation) Validate(value float64, provider RegisterValueProvider) error {
+	valid, err := validation.regFunc.evaluate(provider, map[string]interface{}{validation.varName: value})
+	if err != nil {
+		return err
+	}
+	if !util.CastToBool(valid) {
+		return fmt.Errorf("invalid value '%f'", value)
+	}
+	return nil
 }
 
 type RegisterMapValue struct {
 	ByFunction         func(value float64) float64
 	GetInverseFunction func() (func(float64) float64, error)
 	ByEnumMap          map[int64]string
+	// refs of the functions, see Config.bind
+	refs *references
+}
+
+// RegisterInverse explicitly specifies the inverse of a mapValue function,
+// for functions which util.InvertAndCompile cannot invert
+type RegisterInverse struct {
+	ByFunction func(value float64) float64
+	refs       *references
+}
+
+func (inverse *RegisterInverse) UnmarshalYAML(node *yaml.Node) error {
+	m := map[string]string{}
+	err := node.Decode(m)
+	if err != nil {
+		return err
+	}
+	inverse.refs = &references{}
+	inverse.ByFunction, err = convertOneElementMapToFunction[float64](m, util.Compile, inverse.refs)
+	return nodeError(node, err)
 }
 
 func (mapValue *RegisterMapValue) UnmarshalYAML(node *yaml.Node) error {
@@ -82,12 +383,14 @@ func (mapValue *RegisterMapValue) UnmarshalYAML(node *yaml.Node) error {
 		return typeError("mapValue should not be empty")
 	case 1:
 		{
-			function, err := convertOneElementMapToFunction[float64](m, util.Compile)
+			refs := &references{}
+			function, err := convertOneElementMapToFunction[float64](m, util.Compile, refs)
 			if err == nil {
 				mapValue.ByFunction = function
 				mapValue.GetInverseFunction = func() (func(float64) float64, error) {
-					return convertOneElementMapToFunction[float64](m, util.InvertAndCompile)
+					return convertOneElementMapToFunction[float64](m, util.InvertAndCompile, refs)
 				}
+				mapValue.refs = refs
 				return nil
 			}
 			log.Warnf("Assuming one-element enum map instead of function, caused by: %s", err.Error())
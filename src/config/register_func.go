@@ -1,46 +1,178 @@
 package config
 
 import (
+	"fmt"
 	"github.com/antonmedv/expr"
 	"github.com/antonmedv/expr/vm"
 	"github.com/pkg/errors"
+	"math"
 	"sungrow-prometheus-exporter/src/util"
+	"time"
 )
 
-type RegisterValueProvider func(registerName string) float64
+// RegisterValueProvider returns all values of the register,
+// that is one value unless the register is an array
+type RegisterValueProvider func(registerName string) []float64
 
+// registerFunc is safe for concurrent use,
+// as each evaluation runs with its own environment
 type registerFunc struct {
+	source        string
+	envs          []*util.EnvEntry
 	registerNames []string
-	evaluate      func(provider RegisterValueProvider) (interface{}, error)
+	// metricNames are referenced with metric('name')
+	metricNames []string
+	// enumRegisterNames are referenced with enumName and enumValue
+	enumRegisterNames []string
+	program           *vm.Program
+	windows           *windowState
+	// refs are bound once all configs are read, see Config.bind
+	refs *references
 }
 
-func newRegisterFunc(input string, envs ...*util.EnvEntry) (*registerFunc, error) {
-	program, err := expr.Compile(input)
+// newRegisterFunc type checks the expression against the env,
+// the expected result type can be given as option, like expr.AsFloat64
+func newRegisterFunc(input string, envs []*util.EnvEntry, ops ...expr.Option) (*registerFunc, error) {
+	f := &registerFunc{source: input, envs: envs, windows: &windowState{}}
+	patcher := &windowCallPatcher{state: f.windows}
+	program, err := expr.Compile(input, append([]expr.Option{expr.Env(f.buildEnv(nil, nil, time.Time{}, 0)), expr.Patch(patcher)}, ops...)...)
+	if err == nil {
+		err = patcher.err
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot compile '%s'", input)
+	}
+	calls, err := util.FindFunctionCalls(input, "register", "registerArray", "metric", "enumName", "enumValue")
 	if err != nil {
 		return nil, errors.Wrapf(err, "cannot compile '%s'", input)
 	}
-	registerValues := make(map[string]float64)
-	env := util.BuildEnv(
-		util.Env("register", func(args ...interface{}) (interface{}, error) {
-			registerName := args[0].(string)
-			registerValue, found := registerValues[registerName]
-			if !found {
-				registerValues[registerName] = 0
+	f.program = program
+	f.registerNames = unique(append(calls["register"], calls["registerArray"]...))
+	f.metricNames = unique(calls["metric"])
+	f.enumRegisterNames = unique(append(calls["enumName"], calls["enumValue"]...))
+	return f, nil
+}
+
+// bind checks the referenced enums, as they can only be checked once the registers are read
+func (f *registerFunc) bind(refs *references) error {
+	f.refs = refs
+	for _, registerName := range f.enumRegisterNames {
+		if _, err := refs.findEnumMap(registerName); err != nil {
+			return errors.Wrapf(err, "cannot compile '%s'", f.source)
+		}
+	}
+	return nil
+}
+
+func (f *registerFunc) buildEnv(provider RegisterValueProvider, variables map[string]interface{}, now time.Time, interval time.Duration) map[string]interface{} {
+	env := util.BuildEnv(append(referenceEnv(f.refs), f.envs...)...)
+	for name, value := range f.windows.env(now, interval) {
+		env[name] = value
+	}
+	env["register"] = func(registerName string, args ...interface{}) (float64, error) {
+		if len(args) > 1 {
+			return 0, fmt.Errorf("register expects name and optional index, got %d arguments", len(args)+1)
+		}
+		index := 0
+		if len(args) == 1 {
+			var err error
+			index, err = toIndex(args[0])
+			if err != nil {
+				return 0, err
 			}
-			return registerValue, nil
-		}).And(envs)...)
-	_, err = vm.Run(program, env)
+		}
+		values := provider(registerName)
+		if index < 0 || index >= len(values) {
+			return 0, fmt.Errorf("index %d out of range of register %s with length %d", index, registerName, len(values))
+		}
+		return values[index], nil
+	}
+	env["registerArray"] = func(registerName string) []float64 {
+		return provider(registerName)
+	}
+	env["metric"] = func(metricName string) (float64, error) {
+		return f.refs.metricValue(metricName, provider)
+	}
+	for name, value := range variables {
+		env[name] = value
+	}
+	return env
+}
+
+// evaluate overrides the env with the given variables
+func (f *registerFunc) evaluate(provider RegisterValueProvider, variables map[string]interface{}) (interface{}, error) {
+	return f.evaluateAt(provider, variables, time.Now())
+}
+
+// evaluateAt evaluates window functions at the given time
+func (f *registerFunc) evaluateAt(provider RegisterValueProvider, variables map[string]interface{}, now time.Time) (interface{}, error) {
+	return f.run(provider, variables, now, 0)
+}
+
+// record evaluates the expression recording the values of window functions
+func (f *registerFunc) record(provider RegisterValueProvider, now time.Time, interval time.Duration) error {
+	_, err := f.run(provider, nil, now, interval)
+	return err
+}
+
+func (f *registerFunc) run(provider RegisterValueProvider, variables map[string]interface{}, now time.Time, interval time.Duration) (interface{}, error) {
+	// read each register at most once per evaluation
+	registerValues := make(map[string][]float64, len(f.registerNames))
+	cachingProvider := func(registerName string) []float64 {
+		values, ok := registerValues[registerName]
+		if !ok {
+			values = provider(registerName)
+			registerValues[registerName] = values
+		}
+		return values
+	}
+	result, err := vm.Run(f.program, f.buildEnv(cachingProvider, variables, now, interval))
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot run '%s'", input)
+		return 0, err
 	}
-	return &registerFunc{util.GetKeys(registerValues), func(provider RegisterValueProvider) (interface{}, error) {
-		for registerName := range registerValues {
-			registerValues[registerName] = provider(registerName)
+	return result, nil
+}
+
+// allRegisterNames includes the registers of referenced metrics
+func (f *registerFunc) allRegisterNames(metrics Metrics) []string {
+	r := f.registerNames
+	for _, metricName := range f.metricNames {
+		if metric, ok := metrics[metricName]; ok {
+			r = append(r, metric.Value.registerNames(metrics)...)
 		}
-		result, err := vm.Run(program, env)
-		if err != nil {
-			return 0, err
+	}
+	return r
+}
+
+// recompile with another expected result type, keeping the references
+func (f *registerFunc) recompile(ops ...expr.Option) (*registerFunc, error) {
+	regFunc, err := newRegisterFunc(f.source, f.envs, ops...)
+	if err != nil {
+		return nil, err
+	}
+	regFunc.refs = f.refs
+	return regFunc, nil
+}
+
+func unique(s []string) []string {
+	m := make(map[string]struct{}, len(s))
+	for _, v := range s {
+		m[v] = struct{}{}
+	}
+	return util.GetKeys(m)
+}
+
+func toIndex(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, fmt.Errorf("index %v is not an integer", n)
 		}
-		return result, nil
-	}}, nil
+		return int(n), nil
+	}
+	return 0, fmt.Errorf("index %v is not numeric", v)
 }
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"sungrow-prometheus-exporter/src/util"
+	"sync"
+	"testing"
+)
+
+func TestRegisterFunc_EvaluateConcurrently(t *testing.T) {
+	regFunc, err := newRegisterFunc("register('a') + x * register('b')", []*util.EnvEntry{util.Env("x", 0.0)})
+	assert.NoError(t, err)
+	sort.Strings(regFunc.registerNames)
+	assert.Equal(t, []string{"a", "b"}, regFunc.registerNames)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		i := float64(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := regFunc.evaluate(func(registerName string) []float64 {
+				if registerName == "a" {
+					return []float64{i}
+				}
+				return []float64{2 * i}
+			}, map[string]interface{}{"x": i})
+			assert.NoError(t, err)
+			assert.Equal(t, i+i*2*i, result)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRegisterFunc_Arrays(t *testing.T) {
+	provider := func(registerName string) []float64 {
+		return map[string][]float64{
+			"yields": {1, 2, 3, 6},
+			"power":  {100},
+		}[registerName]
+	}
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"register('power')", 100.0},
+		{"register('yields', 2)", 3.0},
+		{"register('yields', register('yields', 0))", 2.0},
+		{"sum(registerArray('yields'))", 12.0},
+		{"max(registerArray('yields'))", 6.0},
+		{"min(registerArray('yields'), 0.5)", 0.5},
+		{"avg(registerArray('yields'))", 3.0},
+		{"len(registerArray('yields'))", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			regFunc, err := newRegisterFunc(tt.input, nil)
+			assert.NoError(t, err)
+			result, err := regFunc.evaluate(provider, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+	regFunc, err := newRegisterFunc("register('yields', 4)", nil)
+	assert.NoError(t, err)
+	_, err = regFunc.evaluate(provider, nil)
+	assert.Contains(t, err.Error(), "index 4 out of range of register yields with length 4")
+}
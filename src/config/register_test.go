@@ -0,0 +1,56 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegisters_Location(t *testing.T) {
+	tests := []struct {
+		name      string
+		registers Registers
+		location  string
+		wantErr   bool
+	}{
+		{"no clock", Registers{"power": &Register{Name: "power"}}, "", false},
+		{"timezone", Registers{"clock": &Register{Name: "clock", Type: DateTimeRegisterType, Timezone: "Europe/Berlin"}}, "Europe/Berlin", false},
+		{"utc by default", Registers{"clock": &Register{Name: "clock", Type: DateTimeRegisterType}}, "UTC", false},
+		{"conflicting", Registers{
+			"clock":  &Register{Name: "clock", Type: DateTimeRegisterType, Timezone: "Europe/Berlin"},
+			"clock2": &Register{Name: "clock2", Type: DateTimeRegisterType},
+		}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location, err := tt.registers.Location()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			if tt.location == "" {
+				assert.Nil(t, location)
+			} else {
+				assert.Equal(t, tt.location, location.String())
+			}
+		})
+	}
+}
+
+func TestRegisters_SetTimezone(t *testing.T) {
+	registers := Registers{
+		"clock":  &Register{Name: "clock", Type: DateTimeRegisterType, Timezone: "Europe/Berlin"},
+		"clock2": &Register{Name: "clock2", Type: DateTimeRegisterType},
+		"power":  &Register{Name: "power"},
+	}
+	assert.Error(t, registers.SetTimezone("Invalid/Timezone"))
+	assert.Equal(t, "Europe/Berlin", registers["clock"].Timezone)
+
+	assert.NoError(t, registers.SetTimezone("Asia/Tokyo"))
+	location, err := registers.Location()
+	assert.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", location.String())
+	assert.Empty(t, registers["power"].Timezone)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+type Unit struct {
+	Name      string
+	Plural    string
+	Dimension string
+	// Factor converts values to the base unit of the dimension
+	Factor float64
+}
+
+// units are the known units of registers and metrics
+var units = map[string]*Unit{}
+
+// baseUnits by dimension, following the Prometheus naming conventions
+var baseUnits = map[string]*Unit{}
+
+func init() {
+	for _, unit := range []*Unit{
+		{"joule", "joules", "energy", 1},
+		{"watthour", "watthours", "energy", 3600},
+		{"kilowatthour", "kilowatthours", "energy", 3600000},
+		{"watt", "watts", "power", 1},
+		{"kilowatt", "kilowatts", "power", 1000},
+		{"var", "vars", "reactive_power", 1},
+		{"kilovar", "kilovars", "reactive_power", 1000},
+		{"varsecond", "varseconds", "reactive_energy", 1},
+		{"varhour", "varhours", "reactive_energy", 3600},
+		{"kilovarhour", "kilovarhours", "reactive_energy", 3600000},
+		{"volt", "volts", "voltage", 1},
+		{"ampere", "amperes", "current", 1},
+		{"hertz", "hertz", "frequency", 1},
+		{"celsius", "celsius", "temperature", 1},
+		{"gram", "grams", "mass", 1},
+		{"kilogram", "kilograms", "mass", 1000},
+		{"second", "seconds", "time", 1},
+		{"ratio", "ratio", "ratio", 1},
+		{"percent", "percent", "ratio", 0.01},
+	} {
+		units[unit.Name] = unit
+		if unit.Factor == 1 {
+			baseUnits[unit.Dimension] = unit
+		}
+	}
+}
+
+func GetUnit(name string) (*Unit, error) {
+	unit, ok := units[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit '%s'", name)
+	}
+	return unit, nil
+}
+
+func (u *Unit) BaseUnit() *Unit {
+	return baseUnits[u.Dimension]
+}
+
+// integralDimensions by dimension, integrated over seconds
+var integralDimensions = map[string]string{
+	"power":          "energy",
+	"reactive_power": "reactive_energy",
+}
+
+// Integral returns the base unit of values integrated over seconds,
+// and the factor to convert values to the base unit before integration
+func (u *Unit) Integral() (*Unit, float64, error) {
+	dimension, ok := integralDimensions[u.Dimension]
+	if !ok {
+		return nil, 0, fmt.Errorf("cannot integrate %s of %s", u.Name, u.Dimension)
+	}
+	return baseUnits[dimension], u.Factor, nil
+}
+
+// ConvertTo returns the conversion of values of this unit to the other unit
+func (u *Unit) ConvertTo(to *Unit) (func(value float64) float64, error) {
+	if u.Dimension != to.Dimension {
+		return nil, fmt.Errorf("cannot convert %s of %s to %s of %s", u.Name, u.Dimension, to.Name, to.Dimension)
+	}
+	return func(value float64) float64 {
+		// multiplying first avoids rounding errors of factors like 1/1000
+		return value * u.Factor / to.Factor
+	}, nil
+}
+
+// OutputUnits maps units to the unit exported instead
+type OutputUnits map[*Unit]*Unit
+
+// ParseOutputUnits parses unit names, like watthour to kilowatthour
+func ParseOutputUnits(names map[string]string) (OutputUnits, error) {
+	result := OutputUnits{}
+	for fromName, toName := range names {
+		from, err := GetUnit(fromName)
+		if err != nil {
+			return nil, err
+		}
+		to, err := GetUnit(toName)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := from.ConvertTo(to); err != nil {
+			return nil, errors.Wrap(err, "invalid output unit")
+		}
+		result[from] = to
+	}
+	return result, nil
+}
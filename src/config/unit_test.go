@@ -0,0 +1,80 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestUnit_ConvertTo(t *testing.T) {
+	tests := []struct {
+		from     string
+		to       string
+		value    float64
+		expected float64
+		err      string
+	}{
+		{"watthour", "joule", 2, 7200, ""},
+		{"watthour", "kilowatthour", 1670600, 1670.6, ""},
+		{"percent", "ratio", 50, 0.5, ""},
+		{"gram", "gram", 3, 3, ""},
+		{"watthour", "watt", 1, 0, "cannot convert watthour of energy to watt of power"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			from, err := GetUnit(tt.from)
+			assert.NoError(t, err)
+			to, err := GetUnit(tt.to)
+			assert.NoError(t, err)
+			convert, err := from.ConvertTo(to)
+			if len(tt.err) > 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, convert(tt.value))
+		})
+	}
+}
+
+func TestParseOutputUnits(t *testing.T) {
+	outputUnits, err := ParseOutputUnits(map[string]string{"watthour": "kilowatthour"})
+	assert.NoError(t, err)
+	watthour, _ := GetUnit("watthour")
+	assert.Equal(t, "kilowatthour", outputUnits[watthour].Name)
+
+	_, err = ParseOutputUnits(map[string]string{"watthour": "furlong"})
+	assert.EqualError(t, err, "unknown unit 'furlong'")
+	_, err = ParseOutputUnits(map[string]string{"watthour": "volt"})
+	assert.Error(t, err)
+}
+
+func TestUnits_UnmarshalYAML(t *testing.T) {
+	var registers Registers
+	err := yaml.Unmarshal([]byte("[{name: power, type: u16, address: 1, unit: horsepower}]"), &registers)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid register power: unknown unit 'horsepower'")
+
+	registers = Registers{"energy": &Register{Name: "energy", Unit: "watthour"}}
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"[{name: a, outputUnit: kilowatthour, value: {fromRegister: energy}}]", ""},
+		{"[{name: a, unit: watt, outputUnit: kilowatt, value: {fromExpression: 1}}]", ""},
+		{"[{name: a, unit: horsepower, value: {fromExpression: 1}}]", "unknown unit 'horsepower'"},
+		{"[{name: a, outputUnit: watt, value: {fromRegister: energy}}]", "cannot convert watthour of energy to watt of power"},
+		{"[{name: a, outputUnit: watt, value: {fromExpression: 1}}]", "outputUnit requires a unit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := unmarshalMetrics(tt.input, registers)
+			if len(tt.err) > 0 {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"fmt"
+	"github.com/antonmedv/expr/ast"
+	"math"
+	"sungrow-prometheus-exporter/src/util"
+	"sync"
+	"time"
+)
+
+// windowFunctions aggregate the values of their first argument recorded by the poller
+// within the window given as second argument, like avg_over(register('R057_export_power'), '5m')
+var windowFunctions = map[string]func(values []timedValue) float64{
+	"avg_over": func(values []timedValue) float64 {
+		if len(values) == 0 {
+			return math.NaN()
+		}
+		sum := 0.0
+		for _, v := range values {
+			sum += v.value
+		}
+		return sum / float64(len(values))
+	},
+	"min_over": func(values []timedValue) float64 {
+		return extreme(values, math.Min)
+	},
+	"max_over": func(values []timedValue) float64 {
+		return extreme(values, math.Max)
+	},
+	// delta is the change from the oldest to the latest value
+	"delta": func(values []timedValue) float64 {
+		if len(values) < 2 {
+			return math.NaN()
+		}
+		return values[len(values)-1].value - values[0].value
+	},
+	// rate is the change per second from the oldest to the latest value
+	"rate": func(values []timedValue) float64 {
+		if len(values) < 2 {
+			return math.NaN()
+		}
+		first, last := values[0], values[len(values)-1]
+		return (last.value - first.value) / last.time.Sub(first.time).Seconds()
+	},
+}
+
+// midnightFunctions keep the extreme of the values recorded since midnight,
+// in the timezone of the inverter, like max_since_midnight(register('R020_phase_a_voltage'))
+var midnightFunctions = map[string]func(a, b float64) float64{
+	"min_since_midnight": math.Min,
+	"max_since_midnight": math.Max,
+}
+
+func extreme(values []timedValue, f func(a, b float64) float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	result := values[0].value
+	for _, v := range values[1:] {
+		result = f(result, v.value)
+	}
+	return result
+}
+
+type timedValue struct {
+	time  time.Time
+	value float64
+}
+
+// windowCall is a call of a window or midnight function,
+// identified by the index appended as last argument, see windowCallPatcher
+type windowCall struct {
+	function string
+	// window is zero for midnight functions
+	window time.Duration
+	// values are allocated on the first record, as their number depends on the poll interval
+	values   *util.Ring[timedValue]
+	midnight time.Time
+	extreme  float64
+}
+
+func (c *windowCall) record(value float64, now time.Time, interval time.Duration) {
+	if math.IsNaN(value) {
+		return
+	}
+	if c.window > 0 {
+		if c.values == nil {
+			c.values = util.NewRing[timedValue](int(math.Ceil(float64(c.window)/float64(interval))) + 1)
+		}
+		c.values.Add(timedValue{now, value})
+		return
+	}
+	midnight := util.LocalMidnight(now)
+	if midnight.Equal(c.midnight) {
+		c.extreme = midnightFunctions[c.function](c.extreme, value)
+	} else {
+		c.midnight = midnight
+		c.extreme = value
+	}
+}
+
+func (c *windowCall) evaluate(now time.Time) float64 {
+	if c.window > 0 {
+		var values []timedValue
+		if c.values != nil {
+			for _, v := range c.values.Elements() {
+				if v.time.After(now.Add(-c.window)) && !v.time.After(now) {
+					values = append(values, v)
+				}
+			}
+		}
+		return windowFunctions[c.function](values)
+	}
+	if !util.LocalMidnight(now).Equal(c.midnight) {
+		return math.NaN()
+	}
+	return c.extreme
+}
+
+// windowState holds the calls of an expression, which are evaluated by scrapes
+// and recorded by the poller concurrently
+type windowState struct {
+	mutex sync.Mutex
+	calls []*windowCall
+}
+
+// env returns the window and midnight functions,
+// recording their values if the interval is positive
+func (s *windowState) env(now time.Time, interval time.Duration) map[string]interface{} {
+	env := make(map[string]interface{}, len(windowFunctions)+len(midnightFunctions))
+	for name := range windowFunctions {
+		env[name] = s.function(name, now, interval)
+	}
+	for name := range midnightFunctions {
+		env[name] = s.function(name, now, interval)
+	}
+	return env
+}
+
+func (s *windowState) function(name string, now time.Time, interval time.Duration) func(args ...interface{}) (float64, error) {
+	return func(args ...interface{}) (float64, error) {
+		index, ok := args[len(args)-1].(int)
+		if !ok || index < 0 || index >= len(s.calls) {
+			return 0, fmt.Errorf("unknown call of %s", name)
+		}
+		switch args[0].(type) {
+		case float64, int, int64:
+		default:
+			return 0, fmt.Errorf("argument %v of %s is not numeric", args[0], name)
+		}
+		call := s.calls[index]
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if interval > 0 {
+			call.record(util.NumericToFloat64(args[0]), now, interval)
+		}
+		return call.evaluate(now), nil
+	}
+}
+
+// windowCallPatcher checks the calls of window and midnight functions
+// and appends their index as argument, to find their state on evaluation
+type windowCallPatcher struct {
+	state *windowState
+	err   error
+}
+
+func (p *windowCallPatcher) Enter(*ast.Node) {
+}
+
+func (p *windowCallPatcher) Exit(node *ast.Node) {
+	n, ok := (*node).(*ast.FunctionNode)
+	if !ok || p.err != nil {
+		return
+	}
+	call := &windowCall{function: n.Name}
+	if _, ok := windowFunctions[n.Name]; ok {
+		window, err := parseWindowArgument(n)
+		if err != nil {
+			p.err = err
+			return
+		}
+		call.window = window
+	} else if _, ok := midnightFunctions[n.Name]; ok {
+		if len(n.Arguments) != 1 {
+			p.err = fmt.Errorf("%s expects one argument, got %d", n.Name, len(n.Arguments))
+			return
+		}
+	} else {
+		return
+	}
+	n.Arguments = append(n.Arguments, &ast.IntegerNode{Value: len(p.state.calls)})
+	p.state.calls = append(p.state.calls, call)
+}
+
+func parseWindowArgument(n *ast.FunctionNode) (time.Duration, error) {
+	if len(n.Arguments) != 2 {
+		return 0, fmt.Errorf("%s expects a value and a window, got %d arguments", n.Name, len(n.Arguments))
+	}
+	argument, ok := n.Arguments[1].(*ast.StringNode)
+	if !ok {
+		return 0, fmt.Errorf("window of %s must be a string literal like '5m'", n.Name)
+	}
+	window, err := time.ParseDuration(argument.Value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window of %s: %w", n.Name, err)
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("window %s of %s must be positive", window, n.Name)
+	}
+	return window, nil
+}
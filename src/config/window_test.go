@@ -0,0 +1,98 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sungrow-prometheus-exporter/src/util"
+	"testing"
+	"time"
+)
+
+func TestWindowFunctions(t *testing.T) {
+	util.SetLocalLocation(time.UTC)
+	now := time.Date(2022, 6, 1, 0, 5, 0, 0, time.UTC)
+	records := []struct {
+		time  time.Time
+		value float64
+	}{
+		{now.Add(-24 * time.Hour), 1},
+		// before midnight
+		{now.Add(-6 * time.Minute), 100},
+		{now.Add(-4 * time.Minute), 20},
+		{now.Add(-2 * time.Minute), math.NaN()},
+		{now.Add(-time.Minute), 60},
+		{now, 30},
+	}
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"avg_over(register('power'), '5m')", 110.0 / 3},
+		{"min_over(register('power'), '5m')", 20},
+		{"max_over(register('power'), '90s')", 60},
+		{"delta(register('power'), '5m')", 10},
+		{"rate(register('power'), '5m')", 10.0 / 240},
+		{"delta(register('power'), '1s')", math.NaN()},
+		{"2 * max_since_midnight(register('power'))", 120},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			regFunc, err := newRegisterFunc(tt.input, nil)
+			assert.NoError(t, err)
+			for _, r := range records {
+				err := regFunc.record(func(string) []float64 {
+					return []float64{r.value}
+				}, r.time, time.Minute)
+				assert.NoError(t, err)
+			}
+			result, err := regFunc.evaluateAt(func(string) []float64 {
+				return []float64{0}
+			}, nil, now)
+			assert.NoError(t, err)
+			if math.IsNaN(tt.expected) {
+				assert.True(t, math.IsNaN(result.(float64)), "%v is not NaN", result)
+			} else {
+				assert.InDelta(t, tt.expected, result, 1e-9)
+			}
+		})
+	}
+}
+
+func TestWindowFunctions_RingCapacity(t *testing.T) {
+	regFunc, err := newRegisterFunc("min_over(register('power'), '2m')", nil)
+	assert.NoError(t, err)
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		err := regFunc.record(func(string) []float64 {
+			return []float64{float64(i)}
+		}, now.Add(time.Duration(i-9)*time.Second), time.Minute)
+		assert.NoError(t, err)
+	}
+	// polls faster than the interval overwrite the oldest values
+	assert.Len(t, regFunc.windows.calls[0].values.Elements(), 3)
+	result, err := regFunc.evaluateAt(func(string) []float64 {
+		return []float64{0}
+	}, nil, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, result)
+}
+
+func TestWindowFunctions_Compile(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"avg_over(register('power'))", "avg_over expects a value and a window, got 1 arguments"},
+		{"avg_over(register('power'), 5)", "window of avg_over must be a string literal like '5m'"},
+		{"avg_over(register('power'), '5 minutes')", "invalid window of avg_over"},
+		{"delta(register('power'), '-5m')", "window -5m0s of delta must be positive"},
+		{"max_since_midnight(register('power'), '1d')", "max_since_midnight expects one argument, got 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newRegisterFunc(tt.input, nil)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -2,12 +2,13 @@ package config
 
 import (
 	"fmt"
+	"github.com/antonmedv/expr"
 	"github.com/antonmedv/expr/vm"
 	"gopkg.in/yaml.v3"
 	"sungrow-prometheus-exporter/src/util"
 )
 
-type compilerFunc func(input string) (*vm.Program, error)
+type compilerFunc func(input string, ops ...expr.Option) (*vm.Program, error)
 
 func expectOneElementMap[R any](m map[string]string, consumer func(key, value string) (R, error)) (R, error) {
 	if len(m) != 1 {
@@ -17,36 +18,84 @@ func expectOneElementMap[R any](m map[string]string, consumer func(key, value st
 	return consumer(util.GetOnlyMapElement(m))
 }
 
+// convertOneElementMapToFunction compiles a function of the variable named by the key,
+// the references are set once all configs are read, see bindFunction
 func convertOneElementMapToFunction[X any](
 	m map[string]string,
 	compiler compilerFunc,
+	refs *references,
 	envEntries ...*util.EnvEntry,
 ) (func(X) float64, error) {
 	return expectOneElementMap(m, func(varName, expression string) (func(X) float64, error) {
-		program, err := compiler(expression)
+		buildEnv := func(value X) map[string]interface{} {
+			return util.BuildEnv(util.Env(varName, value).And(append(referenceEnv(refs), envEntries...))...)
+		}
+		var zero X
+		program, err := compiler(expression, expr.Env(buildEnv(zero)), expr.AsFloat64())
 		if err != nil {
 			return nil, err
 		}
 		return func(value X) float64 {
-			result, err := vm.Run(program, util.BuildEnv(util.Env(varName, value).And(envEntries)...))
+			result, err := vm.Run(program, buildEnv(value))
 			util.PanicOnError(err)
 			return util.NumericToFloat64(result)
 		}, nil
 	})
 }
 
+// bindFunction sets the references of functions from convertOneElementMapToFunction
+func bindFunction(functionRefs *references, refs *references) {
+	if functionRefs != nil {
+		*functionRefs = *refs
+	}
+}
+
 func unmarshalNamedSequenceToMap[K util.HasKey](node *yaml.Node, result *map[string]*K) error {
 	var s []K
 	err := node.Decode(&s)
 	if err != nil {
 		return err
 	}
+	lines := make(map[string]int, len(s))
+	for i, item := range s {
+		if line, ok := lines[item.GetKey()]; ok {
+			return nodeError(node.Content[i], fmt.Errorf("duplicate name %s, first defined in line %d", item.GetKey(), line))
+		}
+		lines[item.GetKey()] = node.Content[i].Line
+	}
 	*result = util.MapFromNamedSlice(func(item K) *K {
 		return &item
 	}, s...)
 	return nil
 }
 
+func unmarshalEnum[T ~string](node *yaml.Node, result *T, allowed ...T) error {
+	s := ""
+	err := node.Decode(&s)
+	if err != nil {
+		return err
+	}
+	for _, value := range allowed {
+		if T(s) == value {
+			*result = value
+			return nil
+		}
+	}
+	return typeError("unknown value '%s', expecting one of %v", s, allowed)
+}
+
+// nodeError locates the error in the YAML file
+func nodeError(node *yaml.Node, err error) error {
+	return lineError(node.Line, err)
+}
+
+func lineError(line int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: %s", line, err.Error())}}
+}
+
 func typeError(msg string, a ...any) error {
-	return &yaml.TypeError{Errors: []string{fmt.Sprintf(msg, a)}}
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf(msg, a...)}}
 }
@@ -1,51 +1,114 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"net/http"
 	"os"
+	"os/signal"
 	"sungrow-prometheus-exporter/src/actuator"
 	configPkg "sungrow-prometheus-exporter/src/config"
 	"sungrow-prometheus-exporter/src/modbus"
 	"sungrow-prometheus-exporter/src/prometheus"
 	"sungrow-prometheus-exporter/src/register"
+	"sungrow-prometheus-exporter/src/state"
 	"sungrow-prometheus-exporter/src/util"
+	"syscall"
+	"time"
 )
 
 func main() {
 
 	var inverterAddress string
+	var addressOffset int
+	var unitID uint8
+	var timezone string
+	var legacyMetricNames bool
+	var outputUnits map[string]string
+	var stateFile string
+	var pollInterval time.Duration
+	var saveInterval time.Duration
 
 	rootCmd := &cobra.Command{
 		Use:   "sungrow-prometheus-exporter",
 		Short: "Prometheus Exporter for Sungrow inverters",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			parsedOutputUnits, err := configPkg.ParseOutputUnits(outputUnits)
+			if err != nil {
+				return err
+			}
+			naming := prometheus.Naming{Legacy: legacyMetricNames, OutputUnits: parsedOutputUnits}
+			store, err := state.NewStore(stateFile)
+			if err != nil {
+				return err
+			}
+
 			config, err := configPkg.Read()
 			if err != nil {
 				return err
 			}
+			if err := config.Metrics.CheckPollInterval(pollInterval); err != nil {
+				return err
+			}
 
-			readAddressIntervals, writeAddressIntervals := register.FindAddressIntervals(config.Registers,
-				config.Metrics.FindRegisterNames()...,
-			)
-			readWriter := modbus.NewReadWriter(inverterAddress, readAddressIntervals, writeAddressIntervals)
-			defer readWriter.Close()
+			if len(timezone) > 0 {
+				if err := config.Registers.SetTimezone(timezone); err != nil {
+					return err
+				}
+			}
+			location, err := findLocation(config.Registers)
+			if err != nil {
+				return err
+			}
+			util.SetLocalLocation(location)
 
-			for _, metricConfig := range config.Metrics {
-				prometheus.RegisterMetric(readWriter, metricConfig, config.Registers)
+			registry, err := register.NewRegistry(config.Registers)
+			if err != nil {
+				return err
+			}
+			if err := registry.Validate(config.Actuators.FindRegisterNames(config.Metrics)...); err != nil {
+				return err
+			}
+			if err := registry.ValidateActuators(config.Actuators); err != nil {
+				return err
+			}
+			addressIntervals, err := registry.FindAddressIntervals(config.Metrics.FindRegisterNames()...)
+			if err != nil {
+				return err
 			}
-			prometheus.RegisterHttpHandler("/")
+			readWriter := modbus.NewReadWriter(inverterAddress, addressIntervals, addressOffset, unitID)
+			defer readWriter.Close()
+
+			collector := prometheus.NewCollector(readWriter, config.Metrics, registry, naming, store)
+			// the poller saves the state when stopped by a signal
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			polled := make(chan struct{})
+			go func() {
+				collector.Poll(ctx, pollInterval, saveInterval)
+				close(polled)
+			}()
+			prometheus.RegisterHttpHandler("/", collector)
 
-			actuator.RegisterHttpHandler("/actuator", readWriter, config.Actuators, config.Registers)
+			actuator.RegisterHttpHandler("/actuator", readWriter, config.Actuators, registry)
 
-			listenAndServe(8080)
+			go listenAndServe(8080)
+			<-polled
 			return nil
 		},
 	}
 
 	rootCmd.Flags().StringVar(&inverterAddress, "inverter-address", "sungrow:502", "Address as 'host:port' of inverter")
+	rootCmd.Flags().IntVar(&addressOffset, "address-offset", -1, "Offset added to register addresses, use 0 for devices with zero-based addressing")
+	rootCmd.Flags().Uint8Var(&unitID, "unit-id", 1, "Modbus unit ID of the inverter, used for registers without unitId")
+	rootCmd.Flags().BoolVar(&legacyMetricNames, "legacy-metric-names", false, "Use metric names of previous versions, without base units and _total suffix for counters")
+	rootCmd.Flags().StringToStringVar(&outputUnits, "output-unit", nil, "Units exported instead of the base unit for metrics without outputUnit, like watthour=kilowatthour")
+	rootCmd.Flags().StringVar(&stateFile, "state-file", "", "File keeping state like totals of daily counters and integrals across restarts, like /var/lib/sungrow-prometheus-exporter/state.json, by default kept in memory only")
+	rootCmd.Flags().DurationVar(&pollInterval, "poll-interval", 10*time.Second, "Interval of reading registers of integral metrics, daily counters with mode total and window functions")
+	rootCmd.Flags().DurationVar(&saveInterval, "state-save-interval", 5*time.Minute, "Interval of saving the state file, which is also saved on shutdown")
+	rootCmd.Flags().StringVar(&timezone, "timezone", "", "Timezone of the inverter, used by local time functions in expressions, overrides the timezone of the datetime registers")
 
 	if err := rootCmd.Execute(); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
@@ -59,3 +122,13 @@ func listenAndServe(port int) {
 	err := http.ListenAndServe(address, nil)
 	util.PanicOnError(err)
 }
+
+// findLocation is the timezone of the inverter clock,
+// such that local days agree with the datetime registers
+func findLocation(registers configPkg.Registers) (*time.Location, error) {
+	location, err := registers.Location()
+	if err != nil || location != nil {
+		return location, err
+	}
+	return time.Local, nil
+}
@@ -54,6 +54,14 @@ func (c *Cache) Read(address uint16, quantity uint16, reader Reader) ([]uint16,
 	return c.readCache(address, quantity), nil
 }
 
+// Invalidate forces the next read to update the cache,
+// like after writing registers
+func (c *Cache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastUpdate = time.Time{}
+}
+
 func getSize(addressIntervals util.Intervals[uint16]) uint16 {
 	startAddress := addressIntervals[0].Start
 	endAddress := addressIntervals[len(addressIntervals)-1].End
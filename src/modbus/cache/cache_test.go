@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sungrow-prometheus-exporter/src/util"
+	"testing"
+)
+
+func TestCache_Invalidate(t *testing.T) {
+	c := New(util.Intervals[uint16]{{Start: 10, End: 11}})
+	words := []uint16{1, 2}
+	reads := 0
+	reader := func(address, quantity uint16) ([]uint16, error) {
+		reads++
+		return append([]uint16(nil), words[address-10:address-10+quantity]...), nil
+	}
+	values, err := c.Read(11, 1, reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{2}, values)
+
+	words[1] = 3
+	values, err = c.Read(11, 1, reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{2}, values)
+	assert.Equal(t, 1, reads)
+
+	c.Invalidate()
+	values, err = c.Read(11, 1, reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{3}, values)
+	assert.Equal(t, 2, reads)
+}
@@ -8,36 +8,66 @@ import (
 	"golang.org/x/exp/slices"
 	"io"
 	"os"
+	"sungrow-prometheus-exporter/src/config"
 	"sungrow-prometheus-exporter/src/modbus/cache"
 	"sungrow-prometheus-exporter/src/util"
+	"sync"
 	"syscall"
 	"time"
 )
 
 const (
-	maxQuantity = 125
+	maxQuantity      = 125
+	maxWriteQuantity = 123
 
 	maxReadWriteRetries          = 10
 	initialReadWriteRetryBackoff = 30 * time.Millisecond
 )
 
 type RegisterReadWriter struct {
-	handler    *modbus.TCPClientHandler
-	client     modbus.Client
-	readCache  *cache.Cache
-	writeCache *cache.Cache
+	handler       *modbus.TCPClientHandler
+	client        modbus.Client
+	caches        map[config.RegisterSpace]*cache.Cache
+	addressOffset int
+	defaultUnitID uint8
+	// guards setting the unit ID on the shared handler
+	// until the request using it has been sent
+	unitMutex sync.Mutex
 }
 
-func NewReadWriter(address string, readAddressIntervals, writeAddressIntervals util.Intervals[uint16]) *RegisterReadWriter {
+// NewReadWriter shifts all addresses by the given addressOffset,
+// for example -1 for devices documenting their registers one-based.
+// Registers without unit ID are read from the given defaultUnitID.
+func NewReadWriter(address string, addressIntervals map[config.RegisterSpace]util.Intervals[uint16], addressOffset int, defaultUnitID uint8) *RegisterReadWriter {
 	handler := modbus.NewTCPClientHandler(address)
 	handler.Timeout = 3 * time.Second
 	handler.IdleTimeout = 5 * time.Second
-	handler.SlaveId = 0x1
+	handler.SlaveId = defaultUnitID
 	client := modbus.NewClient(handler)
-	return &RegisterReadWriter{handler, client,
-		cache.New(readAddressIntervals),
-		cache.New(writeAddressIntervals),
+	r := &RegisterReadWriter{
+		handler:       handler,
+		client:        client,
+		caches:        make(map[config.RegisterSpace]*cache.Cache),
+		addressOffset: addressOffset,
+		defaultUnitID: defaultUnitID,
 	}
+	intervalsBySpace := make(map[config.RegisterSpace]util.Intervals[uint16])
+	for space, intervals := range addressIntervals {
+		space = r.resolveSpace(space)
+		intervalsBySpace[space] = append(intervalsBySpace[space], intervals...)
+	}
+	for space, intervals := range intervalsBySpace {
+		log.Infof("Caching registers of %s", space)
+		r.caches[space] = cache.New(intervals)
+	}
+	return r
+}
+
+func (r *RegisterReadWriter) resolveSpace(space config.RegisterSpace) config.RegisterSpace {
+	if space.UnitID == 0 {
+		space.UnitID = r.defaultUnitID
+	}
+	return space
 }
 
 func (r *RegisterReadWriter) Close() {
@@ -45,30 +75,49 @@ func (r *RegisterReadWriter) Close() {
 	util.PanicOnError(err)
 }
 
-func (r *RegisterReadWriter) Read(address, quantity uint16, writable bool) ([]uint16, error) {
-	c := func() *cache.Cache {
-		if writable {
-			return r.writeCache
-		} else {
-			return r.readCache
-		}
-	}()
-	return c.Read(address, quantity, func(address, quantity uint16) ([]uint16, error) {
-		return r.readChunked(address, quantity, writable)
-	})
+func (r *RegisterReadWriter) Read(space config.RegisterSpace, address, quantity uint16) ([]uint16, error) {
+	space = r.resolveSpace(space)
+	reader := func(address, quantity uint16) ([]uint16, error) {
+		return r.readChunked(space, address, quantity)
+	}
+	if c, ok := r.caches[space]; ok {
+		return c.Read(address, quantity, reader)
+	}
+	return reader(address, quantity)
 }
 
-func (r *RegisterReadWriter) WriteAndReadBack(address uint16, values []uint16) ([]uint16, error) {
+func (r *RegisterReadWriter) WriteAndReadBack(unitID uint8, address uint16, values []uint16, writeFunction config.WriteFunction) ([]uint16, error) {
+	space := r.resolveSpace(config.RegisterSpace{UnitID: unitID, Table: config.HoldingRegisterTable})
 	quantity := uint16(len(values))
-	log.Infof("Writing address range %d:%d with values %v", address, address+quantity-1, values)
-	_, err := r.writeWithRetry(address, quantity, convertUInt16ToBytes(values))
+	log.Infof("Writing address range %d:%d of unit %d with values %v", address, address+quantity-1, space.UnitID, values)
+	err := r.writeChunked(space.UnitID, address, values, writeFunction)
+	// also after failed writes, which may have written some chunks,
+	// such that a following read-modify-write does not start from the old values
+	if c, ok := r.caches[space]; ok {
+		c.Invalidate()
+	}
 	if err != nil {
 		return nil, err
 	}
-	return r.awaitStableRead(address, values)
+	return r.awaitStableRead(space, address, values)
 }
 
-func (r *RegisterReadWriter) awaitStableRead(address uint16, expectedValues []uint16) ([]uint16, error) {
+func (r *RegisterReadWriter) writeChunked(unitID uint8, address uint16, values []uint16, writeFunction config.WriteFunction) error {
+	chunkSize := maxWriteQuantity
+	if writeFunction == config.WriteSingleFunction {
+		chunkSize = 1
+	}
+	for offset := 0; offset < len(values); offset += chunkSize {
+		chunk := values[offset:util.Min(offset+chunkSize, len(values))]
+		_, err := r.writeWithRetry(unitID, address+uint16(offset), chunk, writeFunction)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RegisterReadWriter) awaitStableRead(space config.RegisterSpace, address uint16, expectedValues []uint16) ([]uint16, error) {
 	quantity := uint16(len(expectedValues))
 	var previouslyReadValues [][]uint16
 	var errNotEqual = errors.New("read values not equal to expected values")
@@ -94,7 +143,7 @@ func (r *RegisterReadWriter) awaitStableRead(address uint16, expectedValues []ui
 			return false, errors.Wrap(commandErr, "stopped waiting for stable read")
 		},
 		command: func() ([]uint16, error) {
-			readValues, err := r.readChunked(address, quantity, true)
+			readValues, err := r.readChunked(space, address, quantity)
 			log.Infof("Read values %v", readValues)
 			if err != nil {
 				return nil, err
@@ -110,12 +159,12 @@ func (r *RegisterReadWriter) awaitStableRead(address uint16, expectedValues []ui
 	}.doWithRetry(6, 100*time.Millisecond)
 }
 
-func (r *RegisterReadWriter) readChunked(address, quantity uint16, writable bool) ([]uint16, error) {
+func (r *RegisterReadWriter) readChunked(space config.RegisterSpace, address, quantity uint16) ([]uint16, error) {
 	var result []byte
 	leftToRead := quantity
 	offset := uint16(0)
 	for leftToRead > 0 {
-		chunk, err := r.readWithRetry(address+offset, util.Min(leftToRead, maxQuantity), writable)
+		chunk, err := r.readWithRetry(space, address+offset, util.Min(leftToRead, maxQuantity))
 		if err != nil {
 			return nil, err
 		}
@@ -138,30 +187,47 @@ func (r *RegisterReadWriter) onReadWriteRetryError(commandErr error) (bool, erro
 	return util.IsAnyError(commandErr, syscall.EPIPE, syscall.ECONNRESET, io.EOF, io.ErrUnexpectedEOF) || os.IsTimeout(commandErr), nil
 }
 
-func (r *RegisterReadWriter) writeWithRetry(address, quantity uint16, values []byte) (any, error) {
+func (r *RegisterReadWriter) writeWithRetry(unitID uint8, address uint16, values []uint16, writeFunction config.WriteFunction) (any, error) {
+	quantity := uint16(len(values))
 	return doWithRetry(
-		fmt.Sprintf("write %d[%d]", address, quantity),
+		fmt.Sprintf("write %d %d[%d]", unitID, address, quantity),
 		r.onReadWriteRetryError,
 		func() (any, error) {
-			return r.client.WriteMultipleRegisters(address-1, quantity, values)
+			defer r.lockUnit(unitID)()
+			if writeFunction == config.WriteSingleFunction {
+				return r.client.WriteSingleRegister(r.deviceAddress(address), values[0])
+			}
+			return r.client.WriteMultipleRegisters(r.deviceAddress(address), quantity, convertUInt16ToBytes(values))
 		},
 	)
 }
 
-func (r *RegisterReadWriter) readWithRetry(address, quantity uint16, writable bool) ([]byte, error) {
+func (r *RegisterReadWriter) readWithRetry(space config.RegisterSpace, address, quantity uint16) ([]byte, error) {
 	return doWithRetry(
-		fmt.Sprintf("read %d[%d]", address, quantity),
+		fmt.Sprintf("read %s %d[%d]", space, address, quantity),
 		r.onReadWriteRetryError,
 		func() ([]byte, error) {
-			if writable {
-				return r.client.ReadHoldingRegisters(address-1, quantity)
+			defer r.lockUnit(space.UnitID)()
+			if space.Table == config.HoldingRegisterTable {
+				return r.client.ReadHoldingRegisters(r.deviceAddress(address), quantity)
 			} else {
-				return r.client.ReadInputRegisters(address-1, quantity)
+				return r.client.ReadInputRegisters(r.deviceAddress(address), quantity)
 			}
 		},
 	)
 }
 
+// lockUnit sets the unit ID of the next request and returns the unlock function
+func (r *RegisterReadWriter) lockUnit(unitID uint8) func() {
+	r.unitMutex.Lock()
+	r.handler.SlaveId = unitID
+	return r.unitMutex.Unlock
+}
+
+func (r *RegisterReadWriter) deviceAddress(address uint16) uint16 {
+	return uint16(int(address) + r.addressOffset)
+}
+
 func doWithRetry[R any](description string, onError func(commandErr error) (bool, error), command func() (R, error)) (R, error) {
 	return retry[R]{description, onError, command}.doWithRetry(maxReadWriteRetries, initialReadWriteRetryBackoff)
 }
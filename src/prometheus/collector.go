@@ -0,0 +1,499 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"math"
+	"strconv"
+	"strings"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/register"
+	"sungrow-prometheus-exporter/src/state"
+	"sungrow-prometheus-exporter/src/util"
+	"time"
+)
+
+// Collector reads all metrics of one inverter in a single pass
+type Collector struct {
+	reader         register.Reader
+	registry       *register.Registry
+	metrics        []*metricCollector
+	scrapeDuration *prometheus.Desc
+	scrapeErrors   prometheus.Counter
+	rejectedValues *prometheus.CounterVec
+	naming         Naming
+	state          *state.Store
+	// polled are the metrics with state which is updated by the poller, like integrals
+	polled []*polledSeries
+	// windowMetrics are recorded by the poller for window functions like avg_over
+	windowMetrics []*config.Metric
+	// openMetricsMetadata by metric family name, as the Prometheus text format
+	// has neither units nor types like info
+	openMetricsMetadata map[string]*openMetricsMetadata
+}
+
+// metricCollector emits the series of one metric config,
+// the label values of a sample follow the dynamic labels
+type metricCollector struct {
+	name        string
+	families    []*family
+	valueType   prometheus.ValueType
+	labelValues func(provider config.RegisterValueProvider) ([]string, error)
+	samples     func(provider config.RegisterValueProvider) ([]sample, error)
+}
+
+// family is the metric itself or its alias, which keeps the values of the register unit
+type family struct {
+	desc    *prometheus.Desc
+	convert func(value float64) float64
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func NewCollector(reader register.Reader, metricsConfig config.Metrics, registry *register.Registry, naming Naming, store *state.Store) *Collector {
+	c := &Collector{
+		reader:   reader,
+		registry: registry,
+		naming:   naming,
+		state:    store,
+		scrapeDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "scrape_duration_seconds"),
+			"Duration of reading all metrics from the inverter", nil, nil,
+		),
+		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scrape_errors_total",
+			Help:      "Metrics which could not be read from the inverter",
+		}),
+		rejectedValues: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "register_rejected_values_total",
+			Help:      "Register values rejected as invalid or implausible",
+		}, []string{"register", "reason"}),
+		openMetricsMetadata: map[string]*openMetricsMetadata{},
+	}
+	for _, metricConfig := range metricsConfig {
+		c.metrics = append(c.metrics, c.newMetricCollectors(metricConfig)...)
+	}
+	return c
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		for _, f := range m.families {
+			ch <- f.desc
+		}
+	}
+	ch <- c.scrapeDuration
+	c.scrapeErrors.Describe(ch)
+	c.rejectedValues.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	for _, m := range c.metrics {
+		if err := c.collectMetric(m, ch); err != nil {
+			log.Warnf("Cannot read metric %s: %s", m.name, err.Error())
+			c.scrapeErrors.Inc()
+			for _, f := range m.families {
+				ch <- prometheus.NewInvalidMetric(f.desc, err)
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.scrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds())
+	c.scrapeErrors.Collect(ch)
+	c.rejectedValues.Collect(ch)
+}
+
+func (c *Collector) collectMetric(m *metricCollector, ch chan<- prometheus.Metric) error {
+	// read errors within expressions are not returned by the provider
+	var readErr error
+	provider := c.newRegisterValueProvider(&readErr)
+	labelValues, err := m.labelValues(provider)
+	if err != nil {
+		return err
+	}
+	samples, err := m.samples(provider)
+	if err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	for _, s := range samples {
+		for _, f := range m.families {
+			ch <- prometheus.MustNewConstMetric(f.desc, m.valueType, f.convert(s.value), append(append([]string{}, labelValues...), s.labelValues...)...)
+		}
+	}
+	return nil
+}
+
+// newMetricCollectors returns the collector of the metric,
+// followed by the reset timestamp of daily counters exported as gauge
+func (c *Collector) newMetricCollectors(metricConfig *config.Metric) []*metricCollector {
+	// static labels are constant, others are read on each scrape,
+	// such that a changed value like a firmware version starts a new series
+	constLabels := prometheus.Labels{}
+	var dynamicLabelNames []string
+	var dynamicLabelValues []*config.Value
+	for _, labelConfig := range metricConfig.Labels {
+		if staticValue := labelConfig.Value.Static; staticValue != nil {
+			constLabels[labelConfig.Name] = *staticValue
+		} else {
+			dynamicLabelNames = append(dynamicLabelNames, labelConfig.Name)
+			dynamicLabelValues = append(dynamicLabelValues, labelConfig.Value)
+		}
+	}
+	valueType := prometheus.GaugeValue
+	dailyCounter := metricConfig.DailyCounter
+	if metricConfig.Type == config.Counter || metricConfig.Type == config.Integral || dailyCounter != nil && dailyCounter.Mode == config.DailyCounterTotal {
+		valueType = prometheus.CounterValue
+	}
+	if metricConfig.Value.UsesWindowFunctions() {
+		c.windowMetrics = append(c.windowMetrics, metricConfig)
+	}
+	unit, seriesLabels, samples := c.buildSamplesFunc(metricConfig)
+	if dailyCounter != nil && dailyCounter.Mode == config.DailyCounterTotal {
+		samples = c.newDailyTotal(metricConfig.Name, samples).collect
+	}
+	if len(metricConfig.Unit) > 0 {
+		unit = metricConfig.Unit
+	}
+	if metricConfig.Type == config.Integral {
+		var integral *polledSeries
+		integral, unit = c.newIntegral(metricConfig, unit, samples)
+		samples = integral.collect
+		if metricConfig.Integral.IsSplit() {
+			seriesLabels = append(seriesLabels, config.IntegralDirectionLabel)
+		}
+	}
+	variableLabels := append(append([]string{}, dynamicLabelNames...), seriesLabels...)
+	outputUnit, convert := c.naming.outputUnit(unit, metricConfig.OutputUnit)
+	name, unitName := c.naming.buildMetricName(metricConfig.Name, outputUnit, valueType == prometheus.CounterValue)
+	families := []*family{c.newFamily(name, c.buildHelp(metricConfig, unitName), unitName, metricConfig.Type, variableLabels, constLabels, convert)}
+	if len(metricConfig.Alias) > 0 {
+		// aliases keep the unit of the register
+		aliasUnitName := ""
+		if len(unit) > 0 {
+			aliasUnitName = mustGetUnit(unit).Plural
+		}
+		families = append(families, c.newFamily(metricConfig.Alias, c.buildHelp(metricConfig, aliasUnitName), "", metricConfig.Type, variableLabels, constLabels, identity))
+	}
+	result := []*metricCollector{{
+		name:      metricConfig.Name,
+		families:  families,
+		valueType: valueType,
+		labelValues: func(provider config.RegisterValueProvider) ([]string, error) {
+			result := make([]string, len(dynamicLabelValues))
+			for i, valueConfig := range dynamicLabelValues {
+				value, err := c.readStringValue(valueConfig, provider)
+				if err != nil {
+					return nil, fmt.Errorf("label %s: %w", dynamicLabelNames[i], err)
+				}
+				result[i] = value
+			}
+			return result, nil
+		},
+		samples: samples,
+	}}
+	if dailyCounter != nil && dailyCounter.Mode == config.DailyCounterGauge {
+		result = append(result, c.newResetTimestampCollector(metricConfig.Name, dailyCounter.Clock, constLabels))
+	}
+	return result
+}
+
+func (c *Collector) newFamily(name string, help string, unitName string, metricType config.MetricType, variableLabels []string, constLabels prometheus.Labels, convert func(float64) float64) *family {
+	metadata := &openMetricsMetadata{}
+	if metricType == config.Info {
+		if !strings.HasSuffix(name, "_info") {
+			name += "_info"
+		}
+		metadata.metricType = "info"
+	}
+	// OpenMetrics requires the unit as suffix of the name
+	if len(unitName) > 0 && strings.HasSuffix(strings.TrimSuffix(name, "_total"), "_"+unitName) {
+		metadata.unit = unitName
+	}
+	c.openMetricsMetadata[name] = metadata
+	return &family{prometheus.NewDesc(name, help, variableLabels, constLabels), convert}
+}
+
+// buildHelp describes the source of the metric if help is missing
+func (c *Collector) buildHelp(metricConfig *config.Metric, unitName string) string {
+	if len(metricConfig.Help) > 0 {
+		return metricConfig.Help
+	}
+	description := strings.ReplaceAll(metricConfig.Name, "_", " ")
+	help := strings.ToUpper(description[:1]) + description[1:]
+	if len(unitName) > 0 {
+		help += " in " + unitName
+	}
+	valueConfig := metricConfig.Value
+	switch {
+	case metricConfig.Type == config.Info:
+		var registerNames []string
+		for _, infoRegister := range metricConfig.Registers {
+			registerNames = append(registerNames, infoRegister.Name)
+		}
+		help += ", read from registers " + strings.Join(registerNames, ", ")
+	case valueConfig.FromRegister != nil:
+		registerName, _ := config.SplitRegisterName(valueConfig.FromRegister.Name)
+		help += fmt.Sprintf(", read from register %s at address %d", valueConfig.FromRegister.Name, c.registry.GetConfig(registerName).Address)
+	case valueConfig.FromExpression != nil:
+		help += ", computed from " + valueConfig.FromExpression.String()
+	}
+	if metricConfig.Type == config.Integral {
+		help += ", integrated over time"
+		if metricConfig.Integral.IsSplit() {
+			help += " separately by " + config.IntegralDirectionLabel + " of the value"
+		}
+	}
+	return help
+}
+
+// buildSamplesFunc returns the unit and the labels distinguishing the samples
+func (c *Collector) buildSamplesFunc(metricConfig *config.Metric) (string, []string, func(provider config.RegisterValueProvider) ([]sample, error)) {
+	valueConfig := metricConfig.Value
+	if metricConfig.Type == config.StateSet {
+		return "", []string{"state", "code"}, c.buildStateSetSamplesFunc(valueConfig.FromRegister.Name)
+	}
+	if metricConfig.Type == config.Info {
+		return c.buildInfoSamplesFunc(metricConfig.Registers)
+	}
+	if registerValue := valueConfig.FromRegister; registerValue != nil {
+		registerName, flagName := config.SplitRegisterName(registerValue.Name)
+		registerConfig := c.registry.GetConfig(registerName)
+		if registerConfig.Type == config.BitfieldRegisterType && len(flagName) == 0 && len(registerConfig.Flags) > 0 {
+			return registerConfig.Unit, []string{"flag"}, func(config.RegisterValueProvider) ([]sample, error) {
+				var result []sample
+				for _, flag := range registerConfig.Flags {
+					reg := c.registry.MustGet(config.JoinRegisterName(registerName, flag.Name))
+					value, ok, err := c.readOptionalRegister(reg, 0)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						result = append(result, sample{[]string{flag.Name}, value})
+					}
+				}
+				return result, nil
+			}
+		}
+		reg := c.registry.MustGet(registerValue.Name)
+		if registerValue.HasIndex() {
+			return registerConfig.Unit, nil, func(provider config.RegisterValueProvider) ([]sample, error) {
+				index, err := registerValue.GetIndex(provider)
+				if err != nil {
+					return nil, err
+				}
+				return c.readSamples(reg, index)
+			}
+		}
+		if registerConfig.Length > 1 {
+			indexLabel := metricConfig.IndexLabel
+			return registerConfig.Unit, []string{indexLabel.GetName()}, func(provider config.RegisterValueProvider) ([]sample, error) {
+				var clock time.Time
+				if indexLabel.NeedsClock() {
+					var err error
+					if clock, err = c.readClock(indexLabel.Clock); err != nil {
+						return nil, err
+					}
+				}
+				var result []sample
+				for i := uint16(0); i < registerConfig.Length; i++ {
+					samples, err := c.readSamples(reg, i)
+					if err != nil {
+						return nil, err
+					}
+					for _, s := range samples {
+						label := indexLabel.LabelValue(int(i), int(registerConfig.Length), clock)
+						skip, err := indexLabel.Skips(provider, int(i), label, s.value)
+						if err != nil {
+							return nil, err
+						}
+						if !skip {
+							result = append(result, sample{[]string{label}, s.value})
+						}
+					}
+				}
+				return result, nil
+			}
+		}
+		return registerConfig.Unit, nil, func(config.RegisterValueProvider) ([]sample, error) {
+			return c.readSamples(reg, 0)
+		}
+	}
+	expressionConfig := valueConfig.FromExpression
+	if seriesLabel := metricConfig.SeriesLabel; len(seriesLabel) > 0 {
+		return "", []string{seriesLabel}, func(provider config.RegisterValueProvider) ([]sample, error) {
+			value, err := expressionConfig.Evaluate(provider)
+			if err != nil {
+				return nil, err
+			}
+			var result []sample
+			for key, seriesValue := range value.(map[string]interface{}) {
+				switch seriesValue.(type) {
+				case float64, int, int64:
+					result = append(result, sample{[]string{key}, util.NumericToFloat64(seriesValue)})
+				default:
+					log.Warnf("Ignoring non-numeric value %v for key %s of metric %s", seriesValue, key, metricConfig.Name)
+				}
+			}
+			return result, nil
+		}
+	}
+	return "", nil, func(provider config.RegisterValueProvider) ([]sample, error) {
+		value, err := expressionConfig.Evaluate(provider)
+		if err != nil {
+			return nil, err
+		}
+		return []sample{{nil, util.NumericToFloat64(value)}}, nil
+	}
+}
+
+// buildStateSetSamplesFunc labels each state with its code,
+// unknown values are exported as state unknown with the raw code,
+// invalid values are exported without samples
+func (c *Collector) buildStateSetSamplesFunc(registerName string) func(config.RegisterValueProvider) ([]sample, error) {
+	reg := c.registry.MustGet(registerName).(register.IntegerRegister)
+	enumMap := c.registry.GetConfig(registerName).MapValue.ByEnumMap
+	codes := util.GetKeys(enumMap)
+	slices.Sort(codes)
+	return func(config.RegisterValueProvider) ([]sample, error) {
+		value, err := reg.ReadInt64(c.reader, 0)
+		if c.countInvalidValue(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		known := false
+		var result []sample
+		for _, code := range codes {
+			state := 0.0
+			if code == value {
+				state = 1
+				known = true
+			}
+			result = append(result, sample{[]string{enumMap[code], strconv.FormatInt(code, 10)}, state})
+		}
+		if !known {
+			result = append(result, sample{[]string{"unknown", strconv.FormatInt(value, 10)}, 1})
+		}
+		return result, nil
+	}
+}
+
+// buildInfoSamplesFunc returns a single sample labelled with the register values,
+// or no sample if any of them is invalid
+func (c *Collector) buildInfoSamplesFunc(infoRegisters []*config.InfoRegister) (string, []string, func(config.RegisterValueProvider) ([]sample, error)) {
+	var labelNames []string
+	var registers []register.Register
+	for _, infoRegister := range infoRegisters {
+		labelNames = append(labelNames, infoRegister.Label)
+		registers = append(registers, c.registry.MustGet(infoRegister.Name))
+	}
+	return "", labelNames, func(config.RegisterValueProvider) ([]sample, error) {
+		labelValues := make([]string, len(registers))
+		for i, reg := range registers {
+			value, err := reg.ReadString(c.reader)
+			if c.countInvalidValue(err) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("label %s: %w", labelNames[i], err)
+			}
+			labelValues[i] = value
+		}
+		return []sample{{labelValues, 1}}, nil
+	}
+}
+
+// readSamples returns no sample for invalid values
+func (c *Collector) readSamples(reg register.Register, index uint16) ([]sample, error) {
+	value, ok, err := c.readOptionalRegister(reg, index)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return []sample{{nil, value}}, nil
+}
+
+func (c *Collector) readStringValue(valueConfig *config.Value, provider config.RegisterValueProvider) (string, error) {
+	if registerValue := valueConfig.FromRegister; registerValue != nil {
+		reg := c.registry.MustGet(registerValue.Name)
+		if registerValue.HasIndex() {
+			index, err := registerValue.GetIndex(provider)
+			if err != nil {
+				return "", err
+			}
+			value, err := reg.ReadFloat64(c.reader, index)
+			if err != nil {
+				c.countInvalidValue(err)
+				return "", err
+			}
+			return fmt.Sprintf("%v", value), nil
+		}
+		value, err := reg.ReadString(c.reader)
+		c.countInvalidValue(err)
+		return value, err
+	}
+	if expressionConfig := valueConfig.FromExpression; expressionConfig != nil {
+		value, err := expressionConfig.Evaluate(provider)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v", value), nil
+	}
+	if staticValue := valueConfig.Static; staticValue != nil {
+		return *staticValue, nil
+	}
+	return "", errors.New("cannot read value for label")
+}
+
+// newRegisterValueProvider returns NaN for invalid values,
+// and keeps the first read error
+func (c *Collector) newRegisterValueProvider(readErr *error) config.RegisterValueProvider {
+	return func(registerName string) []float64 {
+		reg := c.registry.MustGet(registerName)
+		values := make([]float64, reg.Length())
+		for i := range values {
+			value, ok, err := c.readOptionalRegister(reg, uint16(i))
+			if err != nil && *readErr == nil {
+				*readErr = err
+			}
+			if !ok {
+				value = math.NaN()
+			}
+			values[i] = value
+		}
+		return values
+	}
+}
+
+// readOptionalRegister returns false for invalid values
+func (c *Collector) readOptionalRegister(reg register.Register, index uint16) (float64, bool, error) {
+	value, err := reg.ReadFloat64(c.reader, index)
+	if c.countInvalidValue(err) {
+		return math.NaN(), false, nil
+	}
+	if err != nil {
+		return math.NaN(), false, err
+	}
+	return value, true, nil
+}
+
+// countInvalidValue counts values rejected by the register and reports whether err is such a rejection
+func (c *Collector) countInvalidValue(err error) bool {
+	var invalidValueErr *register.InvalidValueError
+	if !errors.As(err, &invalidValueErr) {
+		return false
+	}
+	c.rejectedValues.WithLabelValues(invalidValueErr.RegisterName, string(invalidValueErr.Reason)).Inc()
+	return true
+}
@@ -0,0 +1,224 @@
+package prometheus
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/register"
+	"sungrow-prometheus-exporter/src/state"
+	"testing"
+)
+
+// fakeReader returns the words by address and fails for addresses without words
+type fakeReader map[uint16]uint16
+
+func (f fakeReader) Read(_ config.RegisterSpace, address, quantity uint16) ([]uint16, error) {
+	result := make([]uint16, quantity)
+	for i := range result {
+		word, ok := f[address+uint16(i)]
+		if !ok {
+			return nil, errors.New("illegal data address")
+		}
+		result[i] = word
+	}
+	return result, nil
+}
+
+const collectorTestRegisters = `
+- name: power
+  type: u16
+  address: 1
+  unit: watt
+- name: energy
+  type: u32
+  address: 2
+  unit: kilowatthour
+- name: serial_number
+  type: string
+  address: 4
+  length: 2
+- name: device_type_code
+  type: u16
+  address: 6
+- name: broken
+  type: u16
+  address: 7
+- name: temperature
+  type: s16
+  address: 8
+  unit: celsius
+  invalidValues: [0x7FFF]
+- name: grid_state
+  type: u16
+  address: 9
+  mapValue:
+    0xAA: off_grid
+    0x55: on_grid
+- name: backup_grid_state
+  type: u16
+  address: 10
+  mapValue:
+    0xAA: off_grid
+    0x55: on_grid
+`
+
+const collectorTestMetrics = `
+- name: power
+  help: Active power
+  type: gauge
+  value:
+    fromRegister: power
+- name: energy
+  help: Total energy
+  type: counter
+  value:
+    fromRegister: energy
+- name: device_info
+  help: Device info
+  type: info
+  registers:
+    - name: device_type_code
+      label: model
+    - name: serial_number
+      label: sn
+- name: broken
+  help: Unreadable value
+  type: gauge
+  value:
+    fromRegister: broken
+- name: temperature
+  help: Temperature
+  type: gauge
+  value:
+    fromRegister: temperature
+- name: grid_state
+  help: Grid state
+  type: stateset
+  value:
+    fromRegister: grid_state
+- name: backup_grid_state
+  help: Grid state of the backup
+  type: stateset
+  value:
+    fromRegister: backup_grid_state
+`
+
+// scrapeDurationPattern matches the value of the scrape duration, which varies
+var scrapeDurationPattern = regexp.MustCompile(`(?m)^(sungrow_scrape_duration_seconds) .*$`)
+
+func newTestHttpHandler(t *testing.T) http.Handler {
+	var registers config.Registers
+	assert.NoError(t, yaml.Unmarshal([]byte(collectorTestRegisters), &registers))
+	var metrics config.Metrics
+	assert.NoError(t, yaml.Unmarshal([]byte(collectorTestMetrics), &metrics))
+	registry, err := register.NewRegistry(registers)
+	assert.NoError(t, err)
+	store, err := state.NewStore("")
+	assert.NoError(t, err)
+	reader := fakeReader{
+		1: 1500,
+		2: 1234, 3: 0,
+		4: 'S'<<8 | 'N', 5: '1'<<8 | '2',
+		6: 0xE13,
+		8: 0x7FFF,
+		9: 0x55,
+		// unknown state
+		10: 0x12,
+	}
+	collector := NewCollector(reader, metrics, registry, Naming{}, store)
+	gatherer := prometheus.NewRegistry()
+	gatherer.MustRegister(collector)
+	return newHttpHandler(gatherer, collector)
+}
+
+// TestHttpHandler compares the output of both formats,
+// the unreadable metric broken is missing like the rejected temperature, but counted as scrape error
+func TestHttpHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		expected    string
+	}{
+		{"text", "text/plain", "text/plain; version=0.0.4; charset=utf-8", `# HELP sungrow_backup_grid_state Grid state of the backup
+# TYPE sungrow_backup_grid_state gauge
+sungrow_backup_grid_state{code="170",state="off_grid"} 0
+sungrow_backup_grid_state{code="18",state="unknown"} 1
+sungrow_backup_grid_state{code="85",state="on_grid"} 0
+# HELP sungrow_device_info Device info
+# TYPE sungrow_device_info gauge
+sungrow_device_info{model="3603",sn="SN12"} 1
+# HELP sungrow_energy_joules_total Total energy
+# TYPE sungrow_energy_joules_total counter
+sungrow_energy_joules_total 4.4424e+09
+# HELP sungrow_grid_state Grid state
+# TYPE sungrow_grid_state gauge
+sungrow_grid_state{code="170",state="off_grid"} 0
+sungrow_grid_state{code="85",state="on_grid"} 1
+# HELP sungrow_power_watts Active power
+# TYPE sungrow_power_watts gauge
+sungrow_power_watts 1500
+# HELP sungrow_register_rejected_values_total Register values rejected as invalid or implausible
+# TYPE sungrow_register_rejected_values_total counter
+sungrow_register_rejected_values_total{reason="sentinel",register="temperature"} 1
+# HELP sungrow_scrape_duration_seconds Duration of reading all metrics from the inverter
+# TYPE sungrow_scrape_duration_seconds gauge
+sungrow_scrape_duration_seconds 0
+# HELP sungrow_scrape_errors_total Metrics which could not be read from the inverter
+# TYPE sungrow_scrape_errors_total counter
+sungrow_scrape_errors_total 1
+`},
+		// the Accept header sent by Prometheus
+		{"openmetrics", "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", "application/openmetrics-text; version=0.0.1; charset=utf-8", `# HELP sungrow_backup_grid_state Grid state of the backup
+# TYPE sungrow_backup_grid_state gauge
+sungrow_backup_grid_state{code="170",state="off_grid"} 0.0
+sungrow_backup_grid_state{code="18",state="unknown"} 1.0
+sungrow_backup_grid_state{code="85",state="on_grid"} 0.0
+# HELP sungrow_device Device info
+# TYPE sungrow_device info
+sungrow_device_info{model="3603",sn="SN12"} 1
+# HELP sungrow_energy_joules Total energy
+# TYPE sungrow_energy_joules counter
+# UNIT sungrow_energy_joules joules
+sungrow_energy_joules_total 4.4424e+09
+# HELP sungrow_grid_state Grid state
+# TYPE sungrow_grid_state gauge
+sungrow_grid_state{code="170",state="off_grid"} 0.0
+sungrow_grid_state{code="85",state="on_grid"} 1.0
+# HELP sungrow_power_watts Active power
+# TYPE sungrow_power_watts gauge
+# UNIT sungrow_power_watts watts
+sungrow_power_watts 1500.0
+# HELP sungrow_register_rejected_values Register values rejected as invalid or implausible
+# TYPE sungrow_register_rejected_values counter
+sungrow_register_rejected_values_total{reason="sentinel",register="temperature"} 1.0
+# HELP sungrow_scrape_duration_seconds Duration of reading all metrics from the inverter
+# TYPE sungrow_scrape_duration_seconds gauge
+sungrow_scrape_duration_seconds 0
+# HELP sungrow_scrape_errors Metrics which could not be read from the inverter
+# TYPE sungrow_scrape_errors counter
+sungrow_scrape_errors_total 1.0
+# EOF
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept", tt.accept)
+			recorder := httptest.NewRecorder()
+			newTestHttpHandler(t).ServeHTTP(recorder, request)
+			response := recorder.Result()
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+			assert.Equal(t, tt.contentType, response.Header.Get("Content-Type"))
+			body, err := io.ReadAll(response.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, scrapeDurationPattern.ReplaceAllString(string(body), "$1 0"))
+		})
+	}
+}
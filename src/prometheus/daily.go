@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strings"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/register"
+	"sungrow-prometheus-exporter/src/state"
+	"time"
+)
+
+// newResetTimestampCollector exports the last midnight of the inverter clock,
+// which is when daily counters reset
+func (c *Collector) newResetTimestampCollector(metricName string, clock string, constLabels prometheus.Labels) *metricCollector {
+	name := prometheus.BuildFQName(namespace, "", metricName+"_reset_timestamp_seconds")
+	help := fmt.Sprintf("Time of the last reset of %s at midnight of register %s", metricName, clock)
+	return &metricCollector{
+		name:      metricName + " reset timestamp",
+		families:  []*family{c.newFamily(name, help, "seconds", config.Gauge, nil, constLabels, identity)},
+		valueType: prometheus.GaugeValue,
+		labelValues: func(config.RegisterValueProvider) ([]string, error) {
+			return nil, nil
+		},
+		samples: func(config.RegisterValueProvider) ([]sample, error) {
+			midnight, err := c.readMidnight(clock)
+			if err != nil {
+				return nil, err
+			}
+			return []sample{{nil, float64(midnight.Unix())}}, nil
+		},
+	}
+}
+
+// newDailyTotal accumulates the samples of a daily counter on each poll into monotonic totals,
+// such that the increase until the reset does not depend on scrapes
+func (c *Collector) newDailyTotal(metricName string, samples func(provider config.RegisterValueProvider) ([]sample, error)) *polledSeries {
+	return c.newPolledSeries(metricName, samples,
+		func(entry state.Entry, value float64, _ time.Time) {
+			accumulateDailyValue(entry, value)
+		},
+		func(labelValues []string, entry state.Entry) []sample {
+			if total, ok := entry["total"]; ok {
+				return []sample{{labelValues, total}}
+			}
+			return nil
+		},
+	)
+}
+
+// accumulateDailyValue adds the increase since the last value to the total.
+// Only a decrease is a reset, after which the whole value is the increase,
+// as the daily value of the inverter may reset some time after midnight of its clock.
+func accumulateDailyValue(entry state.Entry, value float64) {
+	last, ok := entry["last"]
+	switch {
+	case math.IsNaN(value):
+		// keep the total, as NaN cannot be undone
+		return
+	case !ok:
+		entry["total"] = value
+	case value < last:
+		entry["total"] += value
+	default:
+		entry["total"] += value - last
+	}
+	entry["last"] = value
+}
+
+func (c *Collector) readMidnight(clock string) (time.Time, error) {
+	t, err := c.readClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), nil
+}
+
+func (c *Collector) readClock(clock string) (time.Time, error) {
+	t, err := c.registry.MustGet(clock).(register.TimeRegister).ReadTime(c.reader)
+	c.countInvalidValue(err)
+	return t, err
+}
+
+// seriesKey identifies the state of a series
+func seriesKey(metricName string, labelValues []string) string {
+	if len(labelValues) == 0 {
+		return metricName
+	}
+	return metricName + "{" + strings.Join(labelValues, ",") + "}"
+}
@@ -0,0 +1,49 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sungrow-prometheus-exporter/src/state"
+	"testing"
+	"time"
+)
+
+func TestAccumulateDailyValue(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected float64
+	}{
+		{5, 5},
+		{7, 7},
+		{math.NaN(), 7},
+		{7.5, 7.5},
+		// the inverter resets its daily value some time after midnight
+		{8, 8},
+		// reset
+		{1, 9},
+		{3, 11},
+		// reset without change of day, like after a restart of the inverter
+		{2, 13},
+	}
+	entry := state.Entry{}
+	for _, tt := range tests {
+		accumulateDailyValue(entry, tt.value)
+		assert.Equal(t, tt.expected, entry["total"])
+	}
+}
+
+func TestDailyTotal(t *testing.T) {
+	store, err := state.NewStore("")
+	assert.NoError(t, err)
+	c := &Collector{state: store}
+	daily := c.newDailyTotal("energy", nil)
+	assert.Equal(t, []*polledSeries{daily}, c.polled)
+	now := time.Date(2022, 6, 1, 23, 50, 0, 0, time.UTC)
+	// polled before and after the reset, without scrape in between
+	daily.record([]sample{{nil, 5}}, now)
+	daily.record([]sample{{nil, 9}}, now.Add(9*time.Minute))
+	daily.record([]sample{{nil, 1}}, now.Add(20*time.Minute))
+	samples, err := daily.collect(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []sample{{nil, 10}}, samples)
+}
@@ -0,0 +1,73 @@
+package prometheus
+
+import (
+	"math"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/state"
+	"sungrow-prometheus-exporter/src/util"
+	"time"
+)
+
+// newIntegral integrates the samples of an integral metric on each poll,
+// the totals are kept in the state
+func (c *Collector) newIntegral(metricConfig *config.Metric, unit string, samples func(provider config.RegisterValueProvider) ([]sample, error)) (*polledSeries, string) {
+	integratedUnit, factor, err := mustGetUnit(unit).Integral()
+	util.PanicOnError(err)
+	maxGap := metricConfig.Integral.GetMaxGap()
+	return c.newPolledSeries(metricConfig.Name, samples,
+		func(entry state.Entry, value float64, now time.Time) {
+			// factor converts values to the base unit before integration
+			integrateValue(entry, value*factor, now, maxGap)
+		},
+		func(labelValues []string, entry state.Entry) []sample {
+			if !metricConfig.Integral.IsSplit() {
+				return []sample{{labelValues, entry[config.IntegralPositive] - entry[config.IntegralNegative]}}
+			}
+			// the positive and negative parts of each series separately
+			var result []sample
+			for _, direction := range []string{config.IntegralPositive, config.IntegralNegative} {
+				result = append(result, sample{append(append([]string{}, labelValues...), direction), entry[direction]})
+			}
+			return result
+		},
+	), integratedUnit.Name
+}
+
+// integrateValue adds the area of the trapezoid since the last value to the totals,
+// unless the last value is older than maxGap. The area below zero is added to the negative total,
+// such that both totals are monotonic like the energy imported and exported by a signed power.
+func integrateValue(entry state.Entry, value float64, now time.Time, maxGap time.Duration) {
+	if math.IsNaN(value) {
+		return
+	}
+	timestamp := float64(now.UnixNano()) / float64(time.Second)
+	if lastTimestamp, ok := entry["timestamp"]; ok {
+		if elapsed := timestamp - lastTimestamp; elapsed > 0 && elapsed <= maxGap.Seconds() {
+			positive, negative := splitArea(entry["value"], value, elapsed)
+			entry[config.IntegralPositive] += positive
+			entry[config.IntegralNegative] += negative
+		}
+	} else {
+		entry[config.IntegralPositive] = 0
+		entry[config.IntegralNegative] = 0
+	}
+	entry["value"] = value
+	entry["timestamp"] = timestamp
+}
+
+// splitArea returns the areas above and below zero of the line from value0 to value1,
+// both positive
+func splitArea(value0, value1, elapsed float64) (positive float64, negative float64) {
+	switch {
+	case value0 >= 0 && value1 >= 0:
+		return (value0 + value1) / 2 * elapsed, 0
+	case value0 <= 0 && value1 <= 0:
+		return 0, -(value0 + value1) / 2 * elapsed
+	}
+	// the line crosses zero in between
+	crossing := elapsed * math.Abs(value0) / (math.Abs(value0) + math.Abs(value1))
+	if value0 > 0 {
+		return value0 / 2 * crossing, -value1 / 2 * (elapsed - crossing)
+	}
+	return value1 / 2 * (elapsed - crossing), -value0 / 2 * crossing
+}
@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/state"
+	"testing"
+	"time"
+)
+
+func TestIntegrateValue(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    float64
+		elapsed  time.Duration
+		positive float64
+		negative float64
+	}{
+		{100, 0, 0, 0},
+		{200, 10 * time.Second, 1500, 0},
+		{math.NaN(), 20 * time.Second, 1500, 0},
+		{200, 30 * time.Second, 5500, 0},
+		// gap longer than maxGap, like when the inverter was unreachable
+		{400, 2 * time.Minute, 5500, 0},
+		{0, 2*time.Minute + 10*time.Second, 7500, 0},
+		{-200, 2*time.Minute + 20*time.Second, 7500, 1000},
+		// zero crossing after 5 seconds
+		{200, 2*time.Minute + 30*time.Second, 8000, 1500},
+	}
+	entry := state.Entry{}
+	for _, tt := range tests {
+		integrateValue(entry, tt.value, start.Add(tt.elapsed), time.Minute)
+		assert.Equal(t, tt.positive, entry["positive"])
+		assert.Equal(t, tt.negative, entry["negative"])
+	}
+}
+
+func TestIntegral_Split(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		options  *config.IntegralOptions
+		expected []sample
+	}{
+		{nil, []sample{{[]string{"1"}, -750}}},
+		{&config.IntegralOptions{Split: true}, []sample{{[]string{"1", "positive"}, 250}, {[]string{"1", "negative"}, 1000}}},
+	}
+	for _, tt := range tests {
+		store, err := state.NewStore("")
+		assert.NoError(t, err)
+		c := &Collector{state: store}
+		integral, unit := c.newIntegral(&config.Metric{Name: "energy", Integral: tt.options}, "watt", nil)
+		assert.Equal(t, "joule", unit)
+		integral.record([]sample{{[]string{"1"}, 100}}, start)
+		integral.record([]sample{{[]string{"1"}, -200}}, start.Add(15*time.Second))
+		samples, err := integral.collect(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, samples)
+	}
+}
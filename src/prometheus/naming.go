@@ -0,0 +1,76 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/util"
+)
+
+// Naming selects names and units of the exported metrics
+type Naming struct {
+	// Legacy names have the plural of the unit as suffix, but no base units and no _total for counters
+	Legacy bool
+	// OutputUnits apply to all metrics without outputUnit
+	OutputUnits config.OutputUnits
+}
+
+// outputUnit returns the unit values are exported in and their conversion,
+// by default the base unit
+func (n Naming) outputUnit(unitName string, outputUnitName string) (*config.Unit, func(float64) float64) {
+	if len(unitName) == 0 {
+		return nil, identity
+	}
+	unit := mustGetUnit(unitName)
+	outputUnit := unit
+	if len(outputUnitName) > 0 {
+		outputUnit = mustGetUnit(outputUnitName)
+	} else if globalOutputUnit, ok := n.OutputUnits[unit]; ok {
+		outputUnit = globalOutputUnit
+	} else if !n.Legacy {
+		outputUnit = unit.BaseUnit()
+	}
+	convert, err := unit.ConvertTo(outputUnit)
+	util.PanicOnError(err)
+	return outputUnit, convert
+}
+
+func identity(value float64) float64 {
+	return value
+}
+
+// buildMetricName returns the full name and the unit as suffix of the name
+func (n Naming) buildMetricName(name string, unit *config.Unit, counter bool) (string, string) {
+	unitName := ""
+	if unit != nil {
+		unitName = unit.Plural
+	}
+	if n.Legacy {
+		return prometheus.BuildFQName(namespace, "", appendSuffix(name, unitName)), unitName
+	}
+	if counter {
+		// the unit goes before the suffix, like energy_total to energy_joules_total
+		name = strings.TrimSuffix(name, "_total")
+	}
+	if !strings.HasSuffix(name, "_"+unitName) {
+		name = appendSuffix(name, unitName)
+	}
+	if counter {
+		name += "_total"
+	}
+	return prometheus.BuildFQName(namespace, "", name), unitName
+}
+
+func appendSuffix(name string, suffix string) string {
+	if len(suffix) == 0 {
+		return name
+	}
+	return name + "_" + suffix
+}
+
+// mustGetUnit expects units to be checked when reading the config
+func mustGetUnit(name string) *config.Unit {
+	unit, err := config.GetUnit(name)
+	util.PanicOnError(err)
+	return unit
+}
@@ -0,0 +1,42 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sungrow-prometheus-exporter/src/config"
+	"testing"
+)
+
+func TestNaming(t *testing.T) {
+	kilowatthour, _ := config.GetUnit("kilowatthour")
+	watthour, _ := config.GetUnit("watthour")
+	tests := []struct {
+		naming     Naming
+		name       string
+		unit       string
+		outputUnit string
+		counter    bool
+		expected   string
+		unitName   string
+		value      float64
+	}{
+		{Naming{}, "power", "watt", "", false, "sungrow_power_watts", "watts", 2},
+		{Naming{}, "energy_total", "watthour", "", true, "sungrow_energy_joules_total", "joules", 7200},
+		{Naming{}, "energy_daily", "watthour", "kilowatthour", true, "sungrow_energy_daily_kilowatthours_total", "kilowatthours", 0.002},
+		{Naming{OutputUnits: config.OutputUnits{watthour: kilowatthour}}, "energy", "watthour", "", false, "sungrow_energy_kilowatthours", "kilowatthours", 0.002},
+		{Naming{}, "frequency_hertz", "hertz", "", false, "sungrow_frequency_hertz", "hertz", 2},
+		{Naming{}, "state", "", "", false, "sungrow_state", "", 2},
+		{Naming{}, "count", "", "", true, "sungrow_count_total", "", 2},
+		{Naming{Legacy: true}, "energy_total", "watthour", "", true, "sungrow_energy_total_watthours", "watthours", 2},
+		{Naming{Legacy: true}, "energy", "watthour", "joule", false, "sungrow_energy_joules", "joules", 7200},
+		{Naming{Legacy: true}, "temperature", "celsius", "", false, "sungrow_temperature_celsius", "celsius", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			outputUnit, convert := tt.naming.outputUnit(tt.unit, tt.outputUnit)
+			name, unitName := tt.naming.buildMetricName(tt.name, outputUnit, tt.counter)
+			assert.Equal(t, tt.expected, name)
+			assert.Equal(t, tt.unitName, unitName)
+			assert.Equal(t, tt.value, convert(2))
+		})
+	}
+}
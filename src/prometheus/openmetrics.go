@@ -0,0 +1,108 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// openMetricsHandler serves OpenMetrics if negotiated, otherwise the fallback,
+// expfmt can neither write units nor types which are missing in the Prometheus text format
+type openMetricsHandler struct {
+	gatherer prometheus.Gatherer
+	fallback http.Handler
+	// metadata by metric family name, families without are written by expfmt only
+	metadata map[string]*openMetricsMetadata
+}
+
+type openMetricsMetadata struct {
+	// metricType overrides the type of the family, like info
+	metricType string
+	unit       string
+}
+
+func (h *openMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if expfmt.NegotiateIncludingOpenMetrics(r.Header) != expfmt.FmtOpenMetrics {
+		h.fallback.ServeHTTP(w, r)
+		return
+	}
+	metricFamilies, err := h.gatherer.Gather()
+	if err != nil {
+		// like promhttp.ContinueOnError
+		log.Warnf("Error gathering metrics: %s", err.Error())
+		if len(metricFamilies) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+	out := bufio.NewWriter(w)
+	for _, metricFamily := range metricFamilies {
+		metadata, ok := h.metadata[metricFamily.GetName()]
+		switch {
+		case ok && metadata.metricType == "info":
+			err = writeOpenMetricsInfo(out, metricFamily)
+		case ok && len(metadata.unit) > 0:
+			err = writeOpenMetricsWithUnit(out, metricFamily, metadata.unit)
+		default:
+			_, err = expfmt.MetricFamilyToOpenMetrics(out, metricFamily)
+		}
+		if err != nil {
+			log.Errorf("Error writing metric family %s: %s", metricFamily.GetName(), err.Error())
+			return
+		}
+	}
+	if _, err = expfmt.FinalizeOpenMetrics(out); err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Errorf("Error writing metrics: %s", err.Error())
+	}
+}
+
+// writeOpenMetricsWithUnit adds the unit after the type written by expfmt
+func writeOpenMetricsWithUnit(out *bufio.Writer, metricFamily *dto.MetricFamily, unit string) error {
+	var buffer bytes.Buffer
+	if _, err := expfmt.MetricFamilyToOpenMetrics(&buffer, metricFamily); err != nil {
+		return err
+	}
+	text := buffer.String()
+	typeStart := strings.Index(text, "# TYPE ")
+	typeLength := strings.IndexByte(text[typeStart:], '\n') + 1
+	shortName := strings.Fields(text[typeStart:])[2]
+	_, err := fmt.Fprintf(out, "%s# UNIT %s %s\n%s", text[:typeStart+typeLength], shortName, unit, text[typeStart+typeLength:])
+	return err
+}
+
+// writeOpenMetricsInfo writes a family of the gauges with value 1 built for info metrics,
+// the family name lacks the _info suffix of the samples
+func writeOpenMetricsInfo(out *bufio.Writer, metricFamily *dto.MetricFamily) error {
+	name := metricFamily.GetName()
+	shortName := strings.TrimSuffix(name, "_info")
+	if metricFamily.Help != nil {
+		if _, err := fmt.Fprintf(out, "# HELP %s %s\n", shortName, openMetricsEscaper.Replace(metricFamily.GetHelp())); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(out, "# TYPE %s info\n", shortName); err != nil {
+		return err
+	}
+	for _, metric := range metricFamily.Metric {
+		var labels []string
+		for _, label := range metric.Label {
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, label.GetName(), openMetricsEscaper.Replace(label.GetValue())))
+		}
+		if _, err := fmt.Fprintf(out, "%s{%s} 1\n", name, strings.Join(labels, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
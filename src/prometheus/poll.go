@@ -0,0 +1,122 @@
+package prometheus
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/state"
+	"sync"
+	"time"
+)
+
+// Poll reads the registers of metrics which need a history, like integrals,
+// until the context is done, starting immediately to know the series after a restart.
+// The state is saved every saveInterval and when the context is done.
+func (c *Collector) Poll(ctx context.Context, interval time.Duration, saveInterval time.Duration) {
+	var pollTicks <-chan time.Time
+	if len(c.polled) > 0 || len(c.windowMetrics) > 0 {
+		log.Infof("Polling %d metrics with state like integrals and %d metrics with window functions every %s", len(c.polled), len(c.windowMetrics), interval)
+		c.poll(time.Now(), interval)
+		pollTicker := time.NewTicker(interval)
+		defer pollTicker.Stop()
+		pollTicks = pollTicker.C
+	}
+	saveTicker := time.NewTicker(saveInterval)
+	defer saveTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.saveState()
+			return
+		case now := <-pollTicks:
+			c.poll(now, interval)
+		case <-saveTicker.C:
+			c.saveState()
+		}
+	}
+}
+
+func (c *Collector) poll(now time.Time, interval time.Duration) {
+	for _, p := range c.polled {
+		var readErr error
+		samples, err := p.samples(c.newRegisterValueProvider(&readErr))
+		if err == nil {
+			err = readErr
+		}
+		if err != nil {
+			// the gap of an integral is skipped if the next poll is too late
+			log.Warnf("Cannot poll metric %s: %s", p.metricName, err.Error())
+			continue
+		}
+		p.record(samples, now)
+	}
+	for _, metricConfig := range c.windowMetrics {
+		// values of failed reads are NaN, which are not recorded
+		var readErr error
+		err := metricConfig.Value.Record(c.newRegisterValueProvider(&readErr), now, interval)
+		if err == nil {
+			err = readErr
+		}
+		if err != nil {
+			log.Warnf("Cannot poll metric %s: %s", metricConfig.Name, err.Error())
+		}
+	}
+}
+
+func (c *Collector) saveState() {
+	if err := c.state.Save(); err != nil {
+		log.Warnf("Cannot save state: %s", err.Error())
+	}
+}
+
+// polledSeries are the series of a metric read by the poller, whose state is kept in the store
+type polledSeries struct {
+	metricName string
+	samples    func(provider config.RegisterValueProvider) ([]sample, error)
+	// update the state of a series with the polled value
+	update func(entry state.Entry, value float64, now time.Time)
+	// totals returns the samples of a series from its state
+	totals func(labelValues []string, entry state.Entry) []sample
+	state  *state.Store
+	mutex  sync.Mutex
+	// series are the label values by state key, known after the first poll
+	series map[string][]string
+}
+
+func (c *Collector) newPolledSeries(metricName string, samples func(provider config.RegisterValueProvider) ([]sample, error), update func(entry state.Entry, value float64, now time.Time), totals func(labelValues []string, entry state.Entry) []sample) *polledSeries {
+	p := &polledSeries{
+		metricName: metricName,
+		samples:    samples,
+		update:     update,
+		totals:     totals,
+		state:      c.state,
+		series:     map[string][]string{},
+	}
+	c.polled = append(c.polled, p)
+	return p
+}
+
+func (p *polledSeries) record(samples []sample, now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, s := range samples {
+		key := seriesKey(p.metricName, s.labelValues)
+		p.series[key] = s.labelValues
+		p.state.Update(key, func(entry state.Entry) {
+			p.update(entry, s.value, now)
+		})
+	}
+}
+
+// collect returns the samples of the state as of the last poll
+func (p *polledSeries) collect(config.RegisterValueProvider) ([]sample, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var result []sample
+	for key, labelValues := range p.series {
+		if entry := p.state.Get(key); entry != nil {
+			result = append(result, p.totals(labelValues, entry)...)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,212 @@
+package register
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/util"
+)
+
+type bitfieldRegister struct {
+	register
+	flags []*config.RegisterFlag
+}
+
+func newBitfieldRegister(registerConfig *config.Register) (*bitfieldRegister, error) {
+	width := uint16(1)
+	if registerConfig.Words > 1 {
+		width = registerConfig.Words
+	}
+	for _, flag := range registerConfig.Flags {
+		if flag.Bits.High >= 16*width {
+			return nil, fmt.Errorf("flag %s exceeds width of bitfield register %s", flag.Name, registerConfig.Name)
+		}
+	}
+	return &bitfieldRegister{
+		newRegister(registerConfig, width),
+		registerConfig.Flags,
+	}, nil
+}
+
+func (r *bitfieldRegister) flag(name string) (*flagRegister, error) {
+	for _, flag := range r.flags {
+		if flag.Name == name {
+			return &flagRegister{r, flag.Bits}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown flag '%s' of register %s", name, r.name)
+}
+
+func (r *bitfieldRegister) getAddressInterval() *util.Interval[uint16] {
+	return &util.Interval[uint16]{Start: r.baseAddress, End: r.baseAddress + r.width - 1}
+}
+
+func (r *bitfieldRegister) readBits(reader Reader) (uint64, error) {
+	data, err := r.read(reader, 0, r.width)
+	if err != nil {
+		return 0, err
+	}
+	result := uint64(0)
+	for i := uint16(0); i < r.width; i++ {
+		result += uint64(data[i]) << (16 * i)
+	}
+	if err := r.checkValue(int64(result), float64(result)); err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+func (r *bitfieldRegister) getValueToWrite(_ Reader, valueProvider func() (string, *float64), _ config.RegisterValueProvider) ([]uint16, error) {
+	if !r.writable {
+		return nil, errNotWritable
+	}
+	value, err := parseBitfieldValue(r.name, valueProvider, 16*r.width)
+	if err != nil {
+		return nil, err
+	}
+	return r.encodeBits(value), nil
+}
+
+func (r *bitfieldRegister) encodeBits(bits uint64) []uint16 {
+	result := make([]uint16, r.width)
+	for i := uint16(0); i < r.width; i++ {
+		result[i] = uint16(bits >> (16 * i))
+	}
+	return result
+}
+
+// flagWrite is the value to write into a flag
+type flagWrite struct {
+	flag          *flagRegister
+	valueProvider func() (string, *float64)
+}
+
+// getFlagsValueToWrite combines the flags into a single read-modify-write,
+// other bits of the register must not be touched
+func (r *bitfieldRegister) getFlagsValueToWrite(reader Reader, writes []flagWrite) ([]uint16, error) {
+	if !r.writable {
+		return nil, errNotWritable
+	}
+	bits, err := r.readBits(reader)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range writes {
+		value, err := parseBitfieldValue(r.name, w.valueProvider, w.flag.bits.Width())
+		if err != nil {
+			return nil, err
+		}
+		bits = bits&^w.flag.mask() | value<<w.flag.bits.Low
+	}
+	return r.encodeBits(bits), nil
+}
+
+func (r *bitfieldRegister) ReadFloat64(reader Reader, _ uint16) (float64, error) {
+	bits, err := r.readBits(reader)
+	if err != nil {
+		return 0, err
+	}
+	return float64(bits), nil
+}
+
+// ReadString lists the flags which are set,
+// bit ranges are listed with their value
+func (r *bitfieldRegister) ReadString(reader Reader) (string, error) {
+	bits, err := r.readBits(reader)
+	if err != nil {
+		return "", err
+	}
+	if len(r.flags) == 0 {
+		return fmt.Sprintf("%#x", bits), nil
+	}
+	var result []string
+	for _, flag := range r.flags {
+		value := extractBits(bits, flag.Bits)
+		if value == 0 {
+			continue
+		}
+		if flag.Bits.Width() == 1 {
+			result = append(result, flag.Name)
+		} else {
+			result = append(result, fmt.Sprintf("%s=%d", flag.Name, value))
+		}
+	}
+	return strings.Join(result, ","), nil
+}
+
+// flagRegister is a view onto a single bit or bit range of a bitfieldRegister
+type flagRegister struct {
+	parent *bitfieldRegister
+	bits   config.BitRange
+}
+
+func (r *flagRegister) Length() uint16 {
+	return 1
+}
+
+func (r *flagRegister) getAddressInterval() *util.Interval[uint16] {
+	return r.parent.getAddressInterval()
+}
+
+func (r *flagRegister) getWriteFunction() config.WriteFunction {
+	return r.parent.getWriteFunction()
+}
+
+func (r *flagRegister) getSpace() config.RegisterSpace {
+	return r.parent.getSpace()
+}
+
+func (r *flagRegister) getValueToWrite(reader Reader, valueProvider func() (string, *float64), _ config.RegisterValueProvider) ([]uint16, error) {
+	return r.parent.getFlagsValueToWrite(reader, []flagWrite{{r, valueProvider}})
+}
+
+func (r *flagRegister) mask() uint64 {
+	return uint64(1<<r.bits.Width()-1) << r.bits.Low
+}
+
+func (r *flagRegister) ReadFloat64(reader Reader, _ uint16) (float64, error) {
+	bits, err := r.parent.readBits(reader)
+	if err != nil {
+		return 0, err
+	}
+	return float64(extractBits(bits, r.bits)), nil
+}
+
+func (r *flagRegister) ReadString(reader Reader) (string, error) {
+	value, err := r.ReadFloat64(reader, 0)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+func extractBits(bits uint64, bitRange config.BitRange) uint64 {
+	return bits >> bitRange.Low & (1<<bitRange.Width() - 1)
+}
+
+func parseBitfieldValue(registerName string, valueProvider func() (string, *float64), bits uint16) (uint64, error) {
+	maxValue := uint64(1)<<bits - 1
+	stringValue, floatValue := valueProvider()
+	if floatValue != nil {
+		if *floatValue < 0 || *floatValue > float64(maxValue) || *floatValue != math.Trunc(*floatValue) {
+			return 0, &OutOfRangeError{registerName, *floatValue, 0, int64(maxValue)}
+		}
+		return uint64(*floatValue), nil
+	}
+	if boolValue, err := strconv.ParseBool(stringValue); err == nil {
+		if boolValue {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(stringValue, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	if value > maxValue {
+		return 0, &OutOfRangeError{registerName, float64(value), 0, int64(maxValue)}
+	}
+	return value, nil
+}
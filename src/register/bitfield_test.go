@@ -0,0 +1,110 @@
+package register
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/util"
+	"testing"
+)
+
+type fixedReader []uint16
+
+func (f fixedReader) Read(_ config.RegisterSpace, _, quantity uint16) ([]uint16, error) {
+	return f[:quantity], nil
+}
+
+func TestBitfieldRegister(t *testing.T) {
+	registersConfig := config.Registers{"state": &config.Register{
+		Name:     "state",
+		Type:     config.BitfieldRegisterType,
+		Writable: true,
+		Flags: []*config.RegisterFlag{
+			{Name: "charging", Bits: config.BitRange{Low: 1, High: 1}},
+			{Name: "mode", Bits: config.BitRange{Low: 4, High: 6}},
+		},
+	}}
+	registry, err := NewRegistry(registersConfig)
+	assert.NoError(t, err)
+	reader := fixedReader{0b1010010}
+	tests := []struct {
+		name     string
+		expected float64
+	}{
+		{"state", 0b1010010},
+		{"state.charging", 1},
+		{"state.mode", 0b101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := registry.MustGet(tt.name).ReadFloat64(reader, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+	s, err := registry.MustGet("state").ReadString(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "charging,mode=5", s)
+
+	toWrite := func(name string, value float64) ([]uint16, error) {
+		return registry.MustGet(name).getValueToWrite(reader, func() (string, *float64) {
+			return "", util.PointerTo(value)
+		}, nil)
+	}
+	values, err := toWrite("state.charging", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{0b1010000}, values)
+	values, err = toWrite("state.mode", 0b011)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{0b0110010}, values)
+	_, err = toWrite("state.mode", 8)
+	assert.IsType(t, &OutOfRangeError{}, err)
+}
+
+// memoryReadWriter keeps the written words of the holding registers
+type memoryReadWriter struct {
+	words          map[uint16]uint16
+	writes         [][]uint16
+	writeFunctions []config.WriteFunction
+}
+
+func (m *memoryReadWriter) Read(_ config.RegisterSpace, address, quantity uint16) ([]uint16, error) {
+	result := make([]uint16, quantity)
+	for i := range result {
+		result[i] = m.words[address+uint16(i)]
+	}
+	return result, nil
+}
+
+func (m *memoryReadWriter) WriteAndReadBack(_ uint8, address uint16, values []uint16, writeFunction config.WriteFunction) ([]uint16, error) {
+	m.writes = append(m.writes, values)
+	m.writeFunctions = append(m.writeFunctions, writeFunction)
+	for i, value := range values {
+		m.words[address+uint16(i)] = value
+	}
+	return values, nil
+}
+
+func TestRegisters_WriteFlags(t *testing.T) {
+	registry, err := NewRegistry(config.Registers{"state": &config.Register{
+		Name:     "state",
+		Type:     config.BitfieldRegisterType,
+		Address:  10,
+		Writable: true,
+		Flags: []*config.RegisterFlag{
+			{Name: "a", Bits: config.BitRange{Low: 0, High: 0}},
+			{Name: "b", Bits: config.BitRange{Low: 1, High: 1}},
+		},
+	}})
+	assert.NoError(t, err)
+	registers, err := registry.Select("state.a", "state.b")
+	assert.NoError(t, err)
+	readWriter := &memoryReadWriter{words: map[uint16]uint16{10: 0b100}}
+	written, err := registers.Write(readWriter, func(string) (string, *float64) {
+		return "true", nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]uint16{{0b111}}, readWriter.writes)
+	value, err := registry.MustGet("state.b").ReadFloat64(written, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, value)
+}
@@ -0,0 +1,132 @@
+package register
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/util"
+	"time"
+)
+
+type dateTimeRegister struct {
+	register
+	fields     []config.DateTimeField
+	location   *time.Location
+	validation *config.RegisterValidation
+}
+
+func newDateTimeRegister(registerConfig *config.Register) (*dateTimeRegister, error) {
+	fields := registerConfig.Fields
+	if len(fields) == 0 {
+		fields = config.DefaultDateTimeFields
+	}
+	location := time.UTC
+	if timezone := registerConfig.Timezone; len(timezone) > 0 {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load timezone of register %s", registerConfig.Name)
+		}
+	}
+	return &dateTimeRegister{
+		newRegister(registerConfig, uint16(len(fields))),
+		fields,
+		location,
+		registerConfig.Validation,
+	}, nil
+}
+
+func (r *dateTimeRegister) getAddressInterval() *util.Interval[uint16] {
+	return &util.Interval[uint16]{Start: r.baseAddress, End: r.baseAddress + r.width - 1}
+}
+
+// ReadTime returns the time in the timezone of the register
+func (r *dateTimeRegister) ReadTime(reader Reader) (time.Time, error) {
+	data, err := r.read(reader, 0, r.width)
+	if err != nil {
+		return time.Time{}, err
+	}
+	values := map[config.DateTimeField]int{
+		config.MonthField: 1,
+		config.DayField:   1,
+	}
+	for i, field := range r.fields {
+		// invalid values apply to each field, like 0xFFFF before the clock is set
+		if err := r.checkRawValue(int64(data[i])); err != nil {
+			return time.Time{}, err
+		}
+		values[field] = int(data[i])
+	}
+	t := time.Date(
+		values[config.YearField],
+		time.Month(values[config.MonthField]),
+		values[config.DayField],
+		values[config.HourField],
+		values[config.MinuteField],
+		values[config.SecondField],
+		0,
+		r.location,
+	)
+	// the plausible range applies to the Unix timestamp
+	if plausibleRange := r.plausibleRange; plausibleRange != nil && !plausibleRange.Contains(float64(t.Unix())) {
+		return time.Time{}, &InvalidValueError{r.name, float64(t.Unix()), InvalidValueReasonImplausible}
+	}
+	return t, nil
+}
+
+func (r *dateTimeRegister) getValueToWrite(_ Reader, valueProvider func() (string, *float64), registerValueProvider config.RegisterValueProvider) ([]uint16, error) {
+	if !r.writable {
+		return nil, errNotWritable
+	}
+	stringValue, floatValue := valueProvider()
+	var t time.Time
+	if floatValue != nil {
+		t = time.Unix(int64(*floatValue), 0)
+	} else {
+		var err error
+		t, err = time.Parse(time.RFC3339, stringValue)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if validation := r.validation; validation != nil {
+		err := validation.Validate(float64(t.Unix()), registerValueProvider)
+		if err != nil {
+			return nil, errors.Wrapf(err, "validation failed for writable register %s", r.name)
+		}
+	}
+	t = t.In(r.location)
+	values := map[config.DateTimeField]int{
+		config.YearField:   t.Year(),
+		config.MonthField:  int(t.Month()),
+		config.DayField:    t.Day(),
+		config.HourField:   t.Hour(),
+		config.MinuteField: t.Minute(),
+		config.SecondField: t.Second(),
+	}
+	if year := values[config.YearField]; year < 0 || year > math.MaxUint16 {
+		return nil, &OutOfRangeError{r.name, float64(t.Unix()), 0, math.MaxUint16}
+	}
+	return util.MapSlice(r.fields, func(field config.DateTimeField) uint16 {
+		return uint16(values[field])
+	}), nil
+}
+
+// ReadFloat64 returns the Unix timestamp in seconds
+func (r *dateTimeRegister) ReadFloat64(reader Reader, _ uint16) (float64, error) {
+	t, err := r.ReadTime(reader)
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.Unix()), nil
+}
+
+// ReadString returns the time formatted as RFC3339,
+// which is also accepted when writing
+func (r *dateTimeRegister) ReadString(reader Reader) (string, error) {
+	t, err := r.ReadTime(reader)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(time.RFC3339), nil
+}
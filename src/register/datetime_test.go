@@ -0,0 +1,32 @@
+package register
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sungrow-prometheus-exporter/src/config"
+	"testing"
+)
+
+func TestDateTimeRegister(t *testing.T) {
+	reg := mustNewFromConfig(t, &config.Register{
+		Name:     "clock",
+		Type:     config.DateTimeRegisterType,
+		Writable: true,
+		Fields:   []config.DateTimeField{config.DayField, config.MonthField, config.YearField, config.HourField, config.MinuteField, config.SecondField},
+		Timezone: "Europe/Berlin",
+	})
+	reader := fixedReader{19, 10, 2026, 14, 30, 5}
+
+	s, err := reg.ReadString(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "2026-10-19T14:30:05+02:00", s)
+
+	timestamp, err := reg.ReadFloat64(reader, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1792413005), timestamp)
+
+	values, err := reg.getValueToWrite(reader, func() (string, *float64) {
+		return "2026-10-19T12:30:05Z", nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16(reader), values)
+}
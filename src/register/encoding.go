@@ -0,0 +1,68 @@
+package register
+
+import (
+	"fmt"
+	"math"
+	"sungrow-prometheus-exporter/src/config"
+)
+
+// OutOfRangeError is returned when a value to be written
+// does not fit into the type of the register
+type OutOfRangeError struct {
+	RegisterName string
+	Value        float64
+	Min, Max     int64
+}
+
+func (e *OutOfRangeError) Error() string {
+	return fmt.Sprintf("value %v is out of range [%d, %d] of register %s", e.Value, e.Min, e.Max, e.RegisterName)
+}
+
+type InvalidValueReason string
+
+const (
+	InvalidValueReasonSentinel    InvalidValueReason = "sentinel"
+	InvalidValueReasonImplausible InvalidValueReason = "implausible"
+)
+
+// InvalidValueError is returned when a read value is one of the
+// configured invalid values or outside the plausible range,
+// it should be treated as absent
+type InvalidValueError struct {
+	RegisterName string
+	Value        float64
+	Reason       InvalidValueReason
+}
+
+func (e *InvalidValueError) Error() string {
+	return fmt.Sprintf("%s value %v of register %s", e.Reason, e.Value, e.RegisterName)
+}
+
+func integerRange[T uint16 | uint32 | int16 | int32]() (minValue, maxValue int64) {
+	switch any(T(0)).(type) {
+	case uint16:
+		return 0, math.MaxUint16
+	case uint32:
+		return 0, math.MaxUint32
+	case int16:
+		return math.MinInt16, math.MaxInt16
+	case int32:
+		return math.MinInt32, math.MaxInt32
+	}
+	panic("unknown integer type")
+}
+
+func round(value float64, mode config.RoundingMode) float64 {
+	switch mode {
+	case config.RoundHalfEven:
+		return math.RoundToEven(value)
+	case config.RoundDown:
+		return math.Floor(value)
+	case config.RoundUp:
+		return math.Ceil(value)
+	case config.RoundTruncate:
+		return math.Trunc(value)
+	default:
+		return math.Round(value)
+	}
+}
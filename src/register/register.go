@@ -3,19 +3,22 @@ package register
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
 	"sungrow-prometheus-exporter/src/config"
 	"sungrow-prometheus-exporter/src/util"
+	"time"
 )
 
 type Reader interface {
-	Read(address, quantity uint16, writable bool) ([]uint16, error)
+	Read(space config.RegisterSpace, address, quantity uint16) ([]uint16, error)
 }
 
 type Writer interface {
-	WriteAndReadBack(address uint16, values []uint16) ([]uint16, error)
+	WriteAndReadBack(unitID uint8, address uint16, values []uint16, writeFunction config.WriteFunction) ([]uint16, error)
 }
 
 type ReadWriter interface {
@@ -24,15 +27,47 @@ type ReadWriter interface {
 }
 
 type Register interface {
+	// Length is the number of elements, which is 1 unless the register is an array
+	Length() uint16
 	ReadFloat64(reader Reader, index uint16) (float64, error)
 	ReadString(reader Reader) (string, error)
 	getAddressInterval() *util.Interval[uint16]
-	getValueToWrite(valueProvider func() (string, *float64), registerValueProvider config.RegisterValueProvider) uint16
+	getWriteFunction() config.WriteFunction
+	getSpace() config.RegisterSpace
+	getValueToWrite(reader Reader, valueProvider func() (string, *float64), registerValueProvider config.RegisterValueProvider) ([]uint16, error)
 }
 
+// TimeRegister is implemented by datetime registers
+type TimeRegister interface {
+	Register
+	ReadTime(reader Reader) (time.Time, error)
+}
+
+// IntegerRegister is implemented by integer registers
+type IntegerRegister interface {
+	Register
+	// ReadInt64 returns the raw value before mapping, like the code of an enum
+	ReadInt64(reader Reader, index uint16) (int64, error)
+}
+
+var errNotWritable = errors.New("register is not writable")
+
 type Registers map[string]Register
 
-func NewFromConfig(registerConfig *config.Register) Register {
+// NewFromConfig rejects writing registers wider than one word with single register writes,
+// which cannot write them atomically
+func NewFromConfig(registerConfig *config.Register) (Register, error) {
+	reg, err := newFromConfig(registerConfig)
+	if err != nil {
+		return nil, err
+	}
+	if registerConfig.WriteFunction == config.WriteSingleFunction && reg.getAddressInterval().Length() > 1 {
+		return nil, fmt.Errorf("register %s with %d words cannot have writeFunction %s", registerConfig.Name, reg.getAddressInterval().Length(), config.WriteSingleFunction)
+	}
+	return reg, nil
+}
+
+func newFromConfig(registerConfig *config.Register) (Register, error) {
 	switch registerConfig.Type {
 	case config.U16RegisterType:
 		return newIntegerRegister[uint16](registerConfig)
@@ -43,29 +78,13 @@ func NewFromConfig(registerConfig *config.Register) Register {
 	case config.S32RegisterType:
 		return newIntegerRegister[int32](registerConfig)
 	case config.StringRegisterType:
-		return newStringRegister(registerConfig)
-	}
-	panic(fmt.Sprintf("unknown register type '%s'", registerConfig.Type))
-}
-
-func NewFromConfigs(registersConfig config.Registers, registerNames ...string) Registers {
-	r := Registers{}
-	for _, registerName := range registerNames {
-		r[registerName] = NewFromConfig(registersConfig[registerName])
+		return newStringRegister(registerConfig), nil
+	case config.BitfieldRegisterType:
+		return newBitfieldRegister(registerConfig)
+	case config.DateTimeRegisterType:
+		return newDateTimeRegister(registerConfig)
 	}
-	return r
-}
-
-func FindAddressIntervals(registerConfigs config.Registers, registerNames ...string) (readAddressIntervals util.Intervals[uint16], writeAddressIntervals util.Intervals[uint16]) {
-	for _, registerName := range registerNames {
-		registerConfig := registerConfigs[registerName]
-		if registerConfig.Writable {
-			writeAddressIntervals = append(writeAddressIntervals, NewFromConfig(registerConfig).getAddressInterval())
-		} else {
-			readAddressIntervals = append(readAddressIntervals, NewFromConfig(registerConfig).getAddressInterval())
-		}
-	}
-	return
+	return nil, fmt.Errorf("unknown register type '%s'", registerConfig.Type)
 }
 
 type registerNameAndValue struct {
@@ -81,48 +100,91 @@ func (r registerNameAndValue) getRegisterName() string {
 	return r.registerName
 }
 
-type WrittenRegisterValues struct {
-	registerSlices util.IntervalSlices[uint16, registerNameAndValue]
+// writeRequest groups registers which may be written with a single request,
+// registers with a different write function start a new request
+type writeRequest struct {
+	unitID        uint8
+	writeFunction config.WriteFunction
 }
 
-func (registers Registers) Write(writer Writer, valueProvider func(registerName string) (string, *float64), registerValueProvider config.RegisterValueProvider) (*WrittenRegisterValues, error) {
+type WrittenRegisterValues struct {
+	registerSlicesByUnitID map[uint8]util.IntervalSlices[uint16, registerNameAndValue]
+}
 
-	registerSlices := util.IntervalSlices[uint16, registerNameAndValue]{}
+// Write writes the registers grouped into as few requests as possible,
+// flags of the same bitfield register are combined into a single write of the register
+func (registers Registers) Write(readWriter ReadWriter, valueProvider func(registerName string) (string, *float64), registerValueProvider config.RegisterValueProvider) (*WrittenRegisterValues, error) {
 
-	for registerName, reg := range registers {
+	registerSlicesByRequest := map[writeRequest]util.IntervalSlices[uint16, registerNameAndValue]{}
+	addValues := func(registerName string, reg Register, values []uint16) error {
 		addressInterval := reg.getAddressInterval()
-		if addressInterval.Length() != 1 {
-			return nil, fmt.Errorf("cannot write into register %s with length != 1", registerName)
+		if uint16(len(values)) != addressInterval.Length() {
+			return fmt.Errorf("cannot write %d values into register %s with length %d", len(values), registerName, addressInterval.Length())
 		}
-		value := reg.getValueToWrite(
-			func() (string, *float64) {
-				return valueProvider(registerName)
-			}, registerValueProvider)
-		registerSlices = append(registerSlices,
-			util.NewIntervalSlice(addressInterval, registerNameAndValue{registerName, value}),
+		request := writeRequest{reg.getSpace().UnitID, reg.getWriteFunction()}
+		registerSlicesByRequest[request] = append(registerSlicesByRequest[request],
+			util.NewIntervalSlice(addressInterval, util.MapSlice(values, func(value uint16) registerNameAndValue {
+				return registerNameAndValue{registerName, value}
+			})...),
 		)
+		return nil
 	}
 
-	registerSlices.SortAndMerge()
-
-	for _, reg := range registerSlices {
-		written, err := writer.WriteAndReadBack(reg.Start, util.MapSlice(reg.Slice, registerNameAndValue.getValue))
+	flagWritesByRegister := map[*bitfieldRegister][]flagWrite{}
+	for registerName, reg := range registers {
+		registerName := registerName
+		space := reg.getSpace()
+		if space.Table != config.HoldingRegisterTable {
+			return nil, fmt.Errorf("cannot write register %s in %s", registerName, space)
+		}
+		valueOfRegister := func() (string, *float64) {
+			return valueProvider(registerName)
+		}
+		if flag, ok := reg.(*flagRegister); ok {
+			flagWritesByRegister[flag.parent] = append(flagWritesByRegister[flag.parent], flagWrite{flag, valueOfRegister})
+			continue
+		}
+		values, err := reg.getValueToWrite(readWriter, valueOfRegister, registerValueProvider)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot write register %s", registerName)
+		}
+		if err := addValues(registerName, reg, values); err != nil {
+			return nil, err
+		}
+	}
+	for reg, flagWrites := range flagWritesByRegister {
+		values, err := reg.getFlagsValueToWrite(readWriter, flagWrites)
 		if err != nil {
+			return nil, errors.Wrapf(err, "cannot write flags of register %s", reg.name)
+		}
+		if err := addValues(reg.name, reg, values); err != nil {
 			return nil, err
 		}
-		for k, value := range written {
-			reg.Slice[k].value = value
+	}
+
+	registerSlicesByUnitID := map[uint8]util.IntervalSlices[uint16, registerNameAndValue]{}
+	for request, registerSlices := range registerSlicesByRequest {
+		registerSlices.SortAndMerge()
+		registerSlicesByUnitID[request.unitID] = append(registerSlicesByUnitID[request.unitID], registerSlices...)
+		for _, reg := range registerSlices {
+			written, err := readWriter.WriteAndReadBack(request.unitID, reg.Start, util.MapSlice(reg.Slice, registerNameAndValue.getValue), request.writeFunction)
+			if err != nil {
+				return nil, err
+			}
+			for k, value := range written {
+				reg.Slice[k].value = value
+			}
 		}
 	}
-	return &WrittenRegisterValues{registerSlices}, nil
+	return &WrittenRegisterValues{registerSlicesByUnitID}, nil
 }
 
-func (w WrittenRegisterValues) Read(startAddress, quantity uint16, writable bool) ([]uint16, error) {
-	if !writable {
-		panic("cannot read non-writable registers")
+func (w WrittenRegisterValues) Read(space config.RegisterSpace, startAddress, quantity uint16) ([]uint16, error) {
+	if space.Table != config.HoldingRegisterTable {
+		panic("can only read written holding registers")
 	}
 	valuesByAddress := make(map[uint16]uint16)
-	for _, reg := range w.registerSlices {
+	for _, reg := range w.registerSlicesByUnitID[space.UnitID] {
 		for k, value := range util.MapSlice(reg.Slice, registerNameAndValue.getValue) {
 			address := reg.Start + uint16(k)
 			valuesByAddress[address] = value
@@ -137,130 +199,226 @@ func (w WrittenRegisterValues) Read(startAddress, quantity uint16, writable bool
 
 func (w WrittenRegisterValues) String() string {
 	var result []string
-	for _, reg := range w.registerSlices {
-		registerNames := util.MapSlice(reg.Slice, registerNameAndValue.getRegisterName)
-		for k, value := range util.MapSlice(reg.Slice, registerNameAndValue.getValue) {
-			result = append(result, fmt.Sprintf("%s=%d", registerNames[k], value))
+	for _, registerSlices := range w.registerSlicesByUnitID {
+		for _, reg := range registerSlices {
+			registerNames := util.MapSlice(reg.Slice, registerNameAndValue.getRegisterName)
+			for k, value := range util.MapSlice(reg.Slice, registerNameAndValue.getValue) {
+				result = append(result, fmt.Sprintf("%s=%d", registerNames[k], value))
+			}
 		}
 	}
 	return strings.Join(result, ", ")
 }
 
 type register struct {
-	baseAddress uint16
-	width       uint16
+	name           string
+	baseAddress    uint16
+	width          uint16
+	space          config.RegisterSpace
+	writable       bool
+	writeFunction  config.WriteFunction
+	invalidValues  []int64
+	plausibleRange *config.ValueRange
 }
 
-type stringRegister struct {
-	register
+func newRegister(registerConfig *config.Register, width uint16) register {
+	return register{
+		registerConfig.Name,
+		registerConfig.Address,
+		width,
+		registerConfig.GetSpace(),
+		registerConfig.Writable,
+		registerConfig.WriteFunction,
+		registerConfig.InvalidValues,
+		registerConfig.PlausibleRange,
+	}
+}
+
+// checkRawValue rejects the configured invalid values like 0xFFFF for no data
+func (r register) checkRawValue(rawValue int64) error {
+	if slices.Contains(r.invalidValues, rawValue) {
+		return &InvalidValueError{r.name, float64(rawValue), InvalidValueReasonSentinel}
+	}
+	return nil
+}
+
+// checkValue rejects invalid raw values and mapped values outside the plausible range,
+// it is shared by all reads of a register
+func (r register) checkValue(rawValue int64, value float64) error {
+	if err := r.checkRawValue(rawValue); err != nil {
+		return err
+	}
+	if plausibleRange := r.plausibleRange; plausibleRange != nil && !plausibleRange.Contains(value) {
+		return &InvalidValueError{r.name, value, InvalidValueReasonImplausible}
+	}
+	return nil
+}
+
+func (r register) read(reader Reader, offset, quantity uint16) ([]uint16, error) {
+	return reader.Read(r.space, r.baseAddress+offset, quantity)
+}
+
+// Length is overridden by array registers
+func (r register) Length() uint16 {
+	return 1
+}
+
+func (r register) getSpace() config.RegisterSpace {
+	return r.space
+}
+
+func (r register) getWriteFunction() config.WriteFunction {
+	return r.writeFunction
 }
 
 type mappers struct {
 	mapToInt64     func(data []uint16) int64
+	mapFromInt64   func(value int64) []uint16
 	mapToFloat64   func(value int64) float64
 	mapToString    func(value int64) string
-	mapFromFloat64 func(value float64, provider config.RegisterValueProvider) uint16
-	mapFromString  func(value string, provider config.RegisterValueProvider) uint16
+	mapFromFloat64 func(value float64, provider config.RegisterValueProvider) (int64, error)
+	mapFromString  func(value string, provider config.RegisterValueProvider) (int64, error)
 }
 
 type integerRegister struct {
 	register
 	mappers
-	length   uint16
-	writable bool
+	length uint16
 }
 
-func (r *integerRegister) getValueToWrite(valueProvider func() (string, *float64), registerValueProvider config.RegisterValueProvider) uint16 {
+const (
+	arrayValueSeparator = ","
+	arrayIndexSeparator = "="
+)
+
+func (r *integerRegister) getValueToWrite(reader Reader, valueProvider func() (string, *float64), registerValueProvider config.RegisterValueProvider) ([]uint16, error) {
 	if !r.writable {
-		panic("register is not writable")
-	}
-	if r.width != 1 {
-		panic("writing register with width > 1 is not supported")
+		return nil, errNotWritable
 	}
 	stringValue, floatValue := valueProvider()
+	if r.length > 1 {
+		if floatValue != nil {
+			return nil, errors.New("cannot write single value into array register")
+		}
+		return r.getArrayValuesToWrite(reader, stringValue, registerValueProvider)
+	}
+	var value int64
+	var err error
 	if floatValue != nil {
-		return r.mapFromFloat64(*floatValue, registerValueProvider)
+		value, err = r.mapFromFloat64(*floatValue, registerValueProvider)
+	} else {
+		value, err = r.mapFromString(stringValue, registerValueProvider)
 	}
-	return r.mapFromString(stringValue, registerValueProvider)
-}
-
-func newStringRegister(registerConfig *config.Register) *stringRegister {
-	return &stringRegister{register{
-		registerConfig.Address,
-		registerConfig.Length,
-	}}
-}
-
-func (r *stringRegister) getAddressInterval() *util.Interval[uint16] {
-	panic("not implemented")
-}
-
-func (r *stringRegister) getValueToWrite(func() (string, *float64), config.RegisterValueProvider) uint16 {
-	panic("cannot write string register")
-}
-
-func (r *stringRegister) ReadFloat64(Reader, uint16) (float64, error) {
-	panic("string register does not have float64 representation")
-}
-
-func (r *stringRegister) ReadString(reader Reader) (string, error) {
-	data, err := reader.Read(r.baseAddress, r.width, false)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return mapToString(data), nil
+	return r.mapFromInt64(value), nil
 }
 
-func mapToString(data []uint16) string {
-	var result []byte
-	for i := 0; i < len(data); i++ {
-		if b := byte(data[i] >> 8); b != 0 {
-			result = append(result, b)
+// getArrayValuesToWrite accepts either a complete list of values like '1,2,3'
+// or a list of index assignments like '0=1,5=3', which is written by read-modify-write
+func (r *integerRegister) getArrayValuesToWrite(reader Reader, value string, registerValueProvider config.RegisterValueProvider) ([]uint16, error) {
+	items := strings.Split(strings.Trim(strings.TrimSpace(value), "[]"), arrayValueSeparator)
+	mapItem := func(item string) ([]uint16, error) {
+		value, err := r.mapFromString(strings.TrimSpace(item), registerValueProvider)
+		if err != nil {
+			return nil, err
 		}
-		if b := byte(data[i] & 0xFF); b != 0 {
-			result = append(result, b)
+		return r.mapFromInt64(value), nil
+	}
+	if !strings.Contains(value, arrayIndexSeparator) {
+		if len(items) != int(r.length) {
+			return nil, fmt.Errorf("expecting %d values for array register, got %d", r.length, len(items))
 		}
+		var result []uint16
+		for _, item := range items {
+			words, err := mapItem(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, words...)
+		}
+		return result, nil
+	}
+	current, err := r.read(reader, 0, r.length*r.width)
+	if err != nil {
+		return nil, err
 	}
-	return string(result)
+	result := append([]uint16(nil), current...)
+	for _, item := range items {
+		indexValue, itemValue, found := strings.Cut(item, arrayIndexSeparator)
+		if !found {
+			return nil, fmt.Errorf("expecting index assignment like '3=42', got '%s'", item)
+		}
+		index, err := strconv.ParseUint(strings.TrimSpace(indexValue), 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		if index >= uint64(r.length) {
+			return nil, fmt.Errorf("index %d out of range for array register with length %d", index, r.length)
+		}
+		words, err := mapItem(itemValue)
+		if err != nil {
+			return nil, err
+		}
+		copy(result[uint16(index)*r.width:], words)
+	}
+	return result, nil
 }
 
-func newIntegerRegister[T uint16 | uint32 | int16 | int32](registerConfig *config.Register) *integerRegister {
+func newIntegerRegister[T uint16 | uint32 | int16 | int32](registerConfig *config.Register) (*integerRegister, error) {
 	width := uint16(reflect.TypeOf(T(0)).Size() / reflect.TypeOf(uint16(0)).Size())
 	length := uint16(1)
 	if registerConfig.Length > 1 {
 		length = registerConfig.Length
 	}
+	m, err := createMappers[T](registerConfig, width)
+	if err != nil {
+		return nil, err
+	}
 	return &integerRegister{
-		register{
-			registerConfig.Address,
-			width,
-		},
-		createMappers[T](registerConfig, width),
+		newRegister(registerConfig, width),
+		*m,
 		length,
-		registerConfig.Writable,
-	}
+	}, nil
 }
 
-func createMappers[T uint16 | uint32 | int16 | int32](registerConfig *config.Register, width uint16) mappers {
-	inverseFunction := func() func(float64) float64 {
-		if inverseFunctionGetter := registerConfig.MapValue.GetInverseFunction; registerConfig.Writable && inverseFunctionGetter != nil {
-			inverseFunction, err := inverseFunctionGetter()
-			util.PanicOnError(errors.Wrapf(err, "no inverse function for writable register %s", registerConfig.Name))
-			return inverseFunction
+func createMappers[T uint16 | uint32 | int16 | int32](registerConfig *config.Register, width uint16) (*mappers, error) {
+	linear, err := newLinearMapping(registerConfig)
+	if err != nil {
+		return nil, err
+	}
+	var inverseFunction func(float64) float64
+	if inverse := registerConfig.Inverse; inverse != nil {
+		inverseFunction = inverse.ByFunction
+	} else if linear != nil {
+		inverseFunction = linear.invert
+	} else if inverseFunctionGetter := registerConfig.MapValue.GetInverseFunction; registerConfig.Writable && inverseFunctionGetter != nil {
+		inverseFunction, err = inverseFunctionGetter()
+		if err != nil {
+			return nil, errors.Wrapf(err, "no inverse function for writable register %s", registerConfig.Name)
 		}
-		return nil
-	}()
-	mapFromFloat64 := func(value float64, provider config.RegisterValueProvider) uint16 {
+	}
+	minValue, maxValue := integerRange[T]()
+	checkRange := func(value float64) (int64, error) {
+		if math.IsNaN(value) || value < float64(minValue) || value > float64(maxValue) {
+			return 0, &OutOfRangeError{registerConfig.Name, value, minValue, maxValue}
+		}
+		return int64(value), nil
+	}
+	mapFromFloat64 := func(value float64, provider config.RegisterValueProvider) (int64, error) {
 		if validation := registerConfig.Validation; validation != nil {
-			err := validation(value, provider)
-			util.PanicOnError(errors.Wrapf(err, "validation failed for writable register %s", registerConfig.Name))
+			err := validation.Validate(value, provider)
+			if err != nil {
+				return 0, errors.Wrapf(err, "validation failed for writable register %s", registerConfig.Name)
+			}
 		}
 		if inverseFunction != nil {
-			return uint16(inverseFunction(value))
+			value = inverseFunction(value)
 		}
-		return uint16(value)
+		return checkRange(round(value, registerConfig.Rounding))
 	}
-	return mappers{
+	return &mappers{
 		mapToInt64: func(data []uint16) int64 {
 			result := T(0)
 			for i := uint16(0); i < width; i++ {
@@ -268,6 +426,16 @@ func createMappers[T uint16 | uint32 | int16 | int32](registerConfig *config.Reg
 			}
 			return int64(result)
 		},
+		mapFromInt64: func(value int64) []uint16 {
+			// lower word first, same order as in mapToInt64,
+			// negative values end up as two's complement
+			bits := uint64(T(value))
+			result := make([]uint16, width)
+			for i := uint16(0); i < width; i++ {
+				result[i] = uint16(bits >> (16 * i))
+			}
+			return result
+		},
 		mapToFloat64: func(value int64) float64 {
 			if mapper := registerConfig.MapValue.ByEnumMap; mapper != nil {
 				if mappedValue, ok := mapper[value]; ok {
@@ -280,6 +448,9 @@ func createMappers[T uint16 | uint32 | int16 | int32](registerConfig *config.Reg
 			if mapper := registerConfig.MapValue.ByFunction; mapper != nil {
 				return mapper(float64(value))
 			}
+			if linear != nil {
+				return linear.apply(float64(value))
+			}
 			return float64(value)
 		},
 		mapToString: func(value int64) string {
@@ -291,42 +462,73 @@ func createMappers[T uint16 | uint32 | int16 | int32](registerConfig *config.Reg
 			if mapper := registerConfig.MapValue.ByFunction; mapper != nil {
 				return fmt.Sprintf("%v", mapper(float64(value)))
 			}
+			if linear != nil {
+				return fmt.Sprintf("%v", linear.apply(float64(value)))
+			}
 			return fmt.Sprintf("%v", value)
 		},
 		mapFromFloat64: mapFromFloat64,
-		mapFromString: func(value string, provider config.RegisterValueProvider) uint16 {
+		mapFromString: func(value string, provider config.RegisterValueProvider) (int64, error) {
 			if mapper := registerConfig.MapValue.ByEnumMap; mapper != nil {
 				if mappedValue := util.GetMapKeyForValue(mapper, value); mappedValue != nil {
-					return uint16(*mappedValue)
+					return checkRange(float64(*mappedValue))
 				}
-				panic(fmt.Sprintf("cannot find value %s in %v", value, util.GetValues(mapper)))
+				return 0, fmt.Errorf("cannot find value %s in %v", value, util.GetValues(mapper))
 			}
 			floatValue, err := strconv.ParseFloat(value, 64)
-			util.PanicOnError(err)
+			if err != nil {
+				return 0, err
+			}
 			return mapFromFloat64(floatValue, provider)
 		},
-	}
+	}, nil
+}
+
+func (r *integerRegister) Length() uint16 {
+	return r.length
 }
 
 func (r *integerRegister) getAddressInterval() *util.Interval[uint16] {
-	return &util.Interval[uint16]{r.baseAddress, r.baseAddress + (r.length-1)*r.width + (r.width - 1)}
+	return &util.Interval[uint16]{Start: r.baseAddress, End: r.baseAddress + (r.length-1)*r.width + (r.width - 1)}
 }
 
+// ReadString returns arrays as list of values,
+// which is also accepted when writing
 func (r *integerRegister) ReadString(reader Reader) (string, error) {
-	data, err := reader.Read(r.baseAddress, r.width, r.writable)
+	data, err := r.read(reader, 0, r.length*r.width)
 	if err != nil {
 		return "", err
 	}
-	return r.mapToString(r.mapToInt64(data)), nil
+	var result []string
+	for i := uint16(0); i < r.length; i++ {
+		rawValue := r.mapToInt64(data[i*r.width:])
+		if err := r.checkValue(rawValue, r.mapToFloat64(rawValue)); err != nil {
+			return "", err
+		}
+		result = append(result, r.mapToString(rawValue))
+	}
+	return strings.Join(result, arrayValueSeparator), nil
 }
 
 func (r *integerRegister) ReadFloat64(reader Reader, index uint16) (float64, error) {
+	rawValue, err := r.ReadInt64(reader, index)
+	if err != nil {
+		return 0, err
+	}
+	return r.mapToFloat64(rawValue), nil
+}
+
+func (r *integerRegister) ReadInt64(reader Reader, index uint16) (int64, error) {
 	if index >= r.length {
-		panic("register index out of range")
+		return 0, fmt.Errorf("index %d out of range of register %s with length %d", index, r.name, r.length)
 	}
-	data, err := reader.Read(r.baseAddress+index*r.width, r.width, r.writable)
+	data, err := r.read(reader, index*r.width, r.width)
 	if err != nil {
 		return 0, err
 	}
-	return r.mapToFloat64(r.mapToInt64(data)), nil
+	rawValue := r.mapToInt64(data)
+	if err := r.checkValue(rawValue, r.mapToFloat64(rawValue)); err != nil {
+		return 0, err
+	}
+	return rawValue, nil
 }
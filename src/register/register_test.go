@@ -0,0 +1,217 @@
+package register
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sungrow-prometheus-exporter/src/config"
+	"testing"
+	"time"
+)
+
+func TestIntegerRegister_getValueToWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   config.Register
+		value    string
+		current  fixedReader
+		expected []uint16
+		readBack string
+	}{
+		{"u32", config.Register{Type: config.U32RegisterType}, "305419896", nil, []uint16{0x5678, 0x1234}, "305419896"},
+		{"s32 negative", config.Register{Type: config.S32RegisterType}, "-2", nil, []uint16{0xFFFE, 0xFFFF}, "-2"},
+		{"s16 negative", config.Register{Type: config.S16RegisterType}, "-1", nil, []uint16{0xFFFF}, "-1"},
+		{"u16 array", config.Register{Type: config.U16RegisterType, Length: 3}, "[1, 2, 3]", nil, []uint16{1, 2, 3}, "1,2,3"},
+		{"u32 array partial", config.Register{Type: config.U32RegisterType, Length: 2}, "1=65536", fixedReader{1, 0, 2, 0}, []uint16{1, 0, 0, 1}, "1,65536"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Writable = true
+			reg := mustNewFromConfig(t, &tt.config)
+			values, err := reg.getValueToWrite(tt.current, func() (string, *float64) {
+				return tt.value, nil
+			}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+			s, err := reg.ReadString(fixedReader(values))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.readBack, s)
+		})
+	}
+}
+
+func TestIntegerRegister_getValueToWriteRoundingAndRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   config.Register
+		value    float64
+		expected []uint16
+		err      error
+	}{
+		{"rounds to nearest by default", config.Register{Type: config.U16RegisterType}, 2.6, []uint16{3}, nil},
+		{"rounds down", config.Register{Type: config.U16RegisterType, Rounding: config.RoundDown}, 2.6, []uint16{2}, nil},
+		{"rounds half to even", config.Register{Type: config.S16RegisterType, Rounding: config.RoundHalfEven}, -2.5, []uint16{0xFFFE}, nil},
+		{"u16 negative", config.Register{Name: "u16", Type: config.U16RegisterType}, -1, nil, &OutOfRangeError{"u16", -1, 0, 65535}},
+		{"u16 overflow", config.Register{Name: "u16", Type: config.U16RegisterType}, 65536, nil, &OutOfRangeError{"u16", 65536, 0, 65535}},
+		{"s16 underflow", config.Register{Name: "s16", Type: config.S16RegisterType}, -32769, nil, &OutOfRangeError{"s16", -32769, -32768, 32767}},
+		{"s32 within range", config.Register{Type: config.S32RegisterType}, -32769, []uint16{0x7FFF, 0xFFFF}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Writable = true
+			values, err := mustNewFromConfig(t, &tt.config).getValueToWrite(nil, func() (string, *float64) {
+				return "", &tt.value
+			}, nil)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+}
+
+func TestIntegerRegister_ReadFloat64InvalidValues(t *testing.T) {
+	minValue, maxValue := -40.0, 120.0
+	reg := mustNewFromConfig(t, &config.Register{
+		Name:           "temperature",
+		Type:           config.S16RegisterType,
+		InvalidValues:  []int64{0x7FFF},
+		PlausibleRange: &config.ValueRange{Min: &minValue, Max: &maxValue},
+	})
+	tests := []struct {
+		name  string
+		data  fixedReader
+		value float64
+		err   error
+	}{
+		{"valid", fixedReader{25}, 25, nil},
+		{"sentinel", fixedReader{0x7FFF}, 0, &InvalidValueError{"temperature", 0x7FFF, InvalidValueReasonSentinel}},
+		{"implausible", fixedReader{0xFF00}, 0, &InvalidValueError{"temperature", -256, InvalidValueReasonImplausible}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := reg.ReadFloat64(tt.data, 0)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+}
+
+func TestRegisters_ReadStringInvalidValues(t *testing.T) {
+	minYear, maxYear := float64(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix()), math.Inf(1)
+	registry, err := NewRegistry(config.Registers{
+		"temperatures": &config.Register{
+			Name:          "temperatures",
+			Type:          config.U16RegisterType,
+			Length:        2,
+			InvalidValues: []int64{0xFFFF},
+		},
+		"state": &config.Register{
+			Name:          "state",
+			Type:          config.BitfieldRegisterType,
+			InvalidValues: []int64{0xFFFF},
+			Flags:         []*config.RegisterFlag{{Name: "charging", Bits: config.BitRange{Low: 1, High: 1}}},
+		},
+		"clock": &config.Register{
+			Name:           "clock",
+			Type:           config.DateTimeRegisterType,
+			Fields:         []config.DateTimeField{config.YearField, config.MonthField, config.DayField, config.HourField, config.MinuteField, config.SecondField},
+			Timezone:       "UTC",
+			InvalidValues:  []int64{0xFFFF},
+			PlausibleRange: &config.ValueRange{Min: &minYear, Max: &maxYear},
+		},
+	})
+	assert.NoError(t, err)
+	tests := []struct {
+		name     string
+		data     fixedReader
+		expected string
+		err      error
+	}{
+		{"temperatures", fixedReader{20, 21}, "20,21", nil},
+		{"temperatures", fixedReader{20, 0xFFFF}, "", &InvalidValueError{"temperatures", 0xFFFF, InvalidValueReasonSentinel}},
+		{"state", fixedReader{0b10}, "charging", nil},
+		{"state", fixedReader{0xFFFF}, "", &InvalidValueError{"state", 0xFFFF, InvalidValueReasonSentinel}},
+		{"state.charging", fixedReader{0xFFFF}, "", &InvalidValueError{"state", 0xFFFF, InvalidValueReasonSentinel}},
+		{"clock", fixedReader{2026, 10, 19, 14, 30, 5}, "2026-10-19T14:30:05Z", nil},
+		{"clock", fixedReader{2026, 0xFFFF, 0xFFFF, 14, 30, 5}, "", &InvalidValueError{"clock", 0xFFFF, InvalidValueReasonSentinel}},
+		{"clock", fixedReader{2000, 1, 1, 0, 0, 0}, "", &InvalidValueError{"clock", 946684800, InvalidValueReasonImplausible}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := registry.MustGet(tt.name).ReadString(tt.data)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestIntegerRegister_Scale(t *testing.T) {
+	scale := 0.1
+	tests := []struct {
+		name   string
+		config config.Register
+		raw    uint16
+		value  float64
+	}{
+		{"scale", config.Register{Type: config.U16RegisterType, Scale: &scale}, 3, 0.3},
+		{"scale and offset", config.Register{Type: config.S16RegisterType, Scale: &scale, Offset: -40}, 123, -27.7},
+		{"explicit inverse", config.Register{
+			Type:     config.U16RegisterType,
+			MapValue: config.RegisterMapValue{ByFunction: func(x float64) float64 { return x * x }},
+			Inverse:  &config.RegisterInverse{ByFunction: math.Sqrt},
+		}, 12, 144},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Writable = true
+			reg := mustNewFromConfig(t, &tt.config)
+			value, err := reg.ReadFloat64(fixedReader{tt.raw}, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.value, value)
+			data, err := reg.getValueToWrite(nil, func() (string, *float64) {
+				return "", &tt.value
+			}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint16{tt.raw}, data)
+		})
+	}
+}
+
+func TestIntegerRegister_Decimals(t *testing.T) {
+	decimals := -1
+	reg := mustNewFromConfig(t, &config.Register{Type: config.U16RegisterType, Decimals: &decimals})
+	value, err := reg.ReadFloat64(fixedReader{123}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 120.0, value)
+
+	_, err = NewFromConfig(&config.Register{
+		Name:     "square",
+		Type:     config.U16RegisterType,
+		Decimals: &decimals,
+		MapValue: config.RegisterMapValue{ByFunction: func(x float64) float64 { return x * x }},
+	})
+	assert.EqualError(t, err, "register square cannot have both scale/offset/decimals and mapValue function")
+}
+
+func TestRegisters_WriteFunctions(t *testing.T) {
+	registry, err := NewRegistry(config.Registers{
+		"mode":  &config.Register{Name: "mode", Type: config.U16RegisterType, Address: 10, Writable: true, WriteFunction: config.WriteSingleFunction},
+		"power": &config.Register{Name: "power", Type: config.U32RegisterType, Address: 11, Writable: true},
+		"limit": &config.Register{Name: "limit", Type: config.U16RegisterType, Address: 13, Writable: true},
+	})
+	assert.NoError(t, err)
+	registers, err := registry.Select("mode", "power", "limit")
+	assert.NoError(t, err)
+	readWriter := &memoryReadWriter{words: map[uint16]uint16{}}
+	_, err = registers.Write(readWriter, func(string) (string, *float64) {
+		return "1", nil
+	}, nil)
+	assert.NoError(t, err)
+	// adjacent registers are only merged if they have the same write function
+	assert.ElementsMatch(t, [][]uint16{{1}, {1, 0, 1}}, readWriter.writes)
+	assert.ElementsMatch(t, []config.WriteFunction{config.WriteSingleFunction, ""}, readWriter.writeFunctions)
+}
+
+func mustNewFromConfig(t *testing.T, registerConfig *config.Register) Register {
+	reg, err := NewFromConfig(registerConfig)
+	assert.NoError(t, err)
+	return reg
+}
@@ -0,0 +1,140 @@
+package register
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/util"
+)
+
+// Registry is built once at startup from the register configs,
+// such that configuration errors are reported early and
+// readily built registers are handed out afterwards
+type Registry struct {
+	configs   config.Registers
+	registers Registers
+}
+
+// NewRegistry builds all registers including the flags of bitfield registers,
+// which are accessible with names according to config.JoinRegisterName
+func NewRegistry(registersConfig config.Registers) (*Registry, error) {
+	registers := Registers{}
+	for name, registerConfig := range registersConfig {
+		reg, err := NewFromConfig(registerConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid register %s", name)
+		}
+		registers[name] = reg
+		if bitfield, ok := reg.(*bitfieldRegister); ok {
+			for _, flag := range registerConfig.Flags {
+				registers[config.JoinRegisterName(name, flag.Name)], err = bitfield.flag(flag.Name)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return &Registry{registersConfig, registers}, nil
+}
+
+// Get also resolves flags of bitfield registers,
+// see config.SplitRegisterName
+func (r *Registry) Get(name string) (Register, error) {
+	if reg, ok := r.registers[name]; ok {
+		return reg, nil
+	}
+	registerName, flagName := config.SplitRegisterName(name)
+	registerConfig, ok := r.configs[registerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown register '%s'", registerName)
+	}
+	if registerConfig.Type != config.BitfieldRegisterType {
+		return nil, fmt.Errorf("register '%s' is not a bitfield, cannot access flag '%s'", registerName, flagName)
+	}
+	return nil, fmt.Errorf("unknown flag '%s' of register %s", flagName, registerName)
+}
+
+// MustGet is meant for names already checked with Validate
+func (r *Registry) MustGet(name string) Register {
+	reg, err := r.Get(name)
+	util.PanicOnError(err)
+	return reg
+}
+
+// GetConfig returns the config of the register, flags are stripped off the name
+func (r *Registry) GetConfig(name string) *config.Register {
+	registerName, _ := config.SplitRegisterName(name)
+	return r.configs[registerName]
+}
+
+func (r *Registry) Validate(names ...string) error {
+	for _, name := range names {
+		if _, err := r.Get(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateActuators checks that the registers of each actuator exist and do not overlap,
+// except for disjoint flags of the same bitfield register, which are written together
+func (r *Registry) ValidateActuators(actuators config.Actuators) error {
+	for name, actuator := range actuators {
+		registerNames := util.GetKeys(actuator.Registers)
+		slices.Sort(registerNames)
+		registers, err := r.Select(registerNames...)
+		if err != nil {
+			return errors.Wrapf(err, "invalid actuator %s", name)
+		}
+		for i, registerName := range registerNames {
+			for _, otherName := range registerNames[i+1:] {
+				if overlap(registers[registerName], registers[otherName]) {
+					return fmt.Errorf("invalid actuator %s: registers %s and %s overlap", name, registerName, otherName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func overlap(a, b Register) bool {
+	if a.getSpace() != b.getSpace() {
+		return false
+	}
+	aInterval, bInterval := a.getAddressInterval(), b.getAddressInterval()
+	if aInterval.End < bInterval.Start || bInterval.End < aInterval.Start {
+		return false
+	}
+	aFlag, aIsFlag := a.(*flagRegister)
+	bFlag, bIsFlag := b.(*flagRegister)
+	if aIsFlag && bIsFlag && aFlag.parent == bFlag.parent {
+		return aFlag.mask()&bFlag.mask() != 0
+	}
+	return true
+}
+
+func (r *Registry) Select(names ...string) (Registers, error) {
+	result := Registers{}
+	for _, name := range names {
+		reg, err := r.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = reg
+	}
+	return result, nil
+}
+
+func (r *Registry) FindAddressIntervals(names ...string) (map[config.RegisterSpace]util.Intervals[uint16], error) {
+	addressIntervals := make(map[config.RegisterSpace]util.Intervals[uint16])
+	for _, name := range names {
+		reg, err := r.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		space := reg.getSpace()
+		addressIntervals[space] = append(addressIntervals[space], reg.getAddressInterval())
+	}
+	return addressIntervals, nil
+}
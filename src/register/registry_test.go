@@ -0,0 +1,90 @@
+package register
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sungrow-prometheus-exporter/src/config"
+	"testing"
+)
+
+func TestNewRegistry(t *testing.T) {
+	zero := 0.0
+	_, err := NewRegistry(config.Registers{"power": &config.Register{Name: "power", Type: config.U16RegisterType, Scale: &zero}})
+	assert.EqualError(t, err, "invalid register power: register power must not have scale 0")
+	_, err = NewRegistry(config.Registers{"clock": &config.Register{Name: "clock", Type: config.DateTimeRegisterType, Timezone: "Mars/Olympus"}})
+	assert.Contains(t, err.Error(), "cannot load timezone of register clock")
+	_, err = NewRegistry(config.Registers{"power": &config.Register{Name: "power", Type: config.U32RegisterType, WriteFunction: config.WriteSingleFunction}})
+	assert.EqualError(t, err, "invalid register power: register power with 2 words cannot have writeFunction single")
+}
+
+func TestRegistry_Get(t *testing.T) {
+	registry, err := NewRegistry(config.Registers{
+		"power": &config.Register{Name: "power", Type: config.U16RegisterType},
+		"state": &config.Register{Name: "state", Type: config.BitfieldRegisterType, Flags: []*config.RegisterFlag{
+			{Name: "charging", Bits: config.BitRange{Low: 1, High: 1}},
+		}},
+	})
+	assert.NoError(t, err)
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"power", ""},
+		{"state", ""},
+		{"state.charging", ""},
+		{"unknown", "unknown register 'unknown'"},
+		{"power.charging", "register 'power' is not a bitfield, cannot access flag 'charging'"},
+		{"state.unknown", "unknown flag 'unknown' of register state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg, err := registry.Get(tt.name)
+			if len(tt.err) > 0 {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Same(t, reg, registry.MustGet(tt.name))
+			}
+		})
+	}
+}
+
+func TestRegistry_ValidateActuators(t *testing.T) {
+	registry, err := NewRegistry(config.Registers{
+		"power": &config.Register{Name: "power", Type: config.U32RegisterType, Address: 10, Writable: true},
+		"limit": &config.Register{Name: "limit", Type: config.U16RegisterType, Address: 11, Writable: true},
+		"other": &config.Register{Name: "other", Type: config.U16RegisterType, Address: 11, Writable: true, UnitID: 2},
+		"state": &config.Register{Name: "state", Type: config.BitfieldRegisterType, Address: 20, Writable: true, Flags: []*config.RegisterFlag{
+			{Name: "a", Bits: config.BitRange{Low: 0, High: 0}},
+			{Name: "b", Bits: config.BitRange{Low: 1, High: 1}},
+			{Name: "ab", Bits: config.BitRange{Low: 0, High: 1}},
+		}},
+	})
+	assert.NoError(t, err)
+	tests := []struct {
+		registerNames []string
+		err           string
+	}{
+		{[]string{"power"}, ""},
+		{[]string{"state.a", "state.b"}, ""},
+		{[]string{"limit", "other"}, ""},
+		{[]string{"power", "limit"}, "invalid actuator test: registers limit and power overlap"},
+		{[]string{"state.a", "state.ab"}, "invalid actuator test: registers state.a and state.ab overlap"},
+		{[]string{"state", "state.b"}, "invalid actuator test: registers state and state.b overlap"},
+		{[]string{"missing"}, "invalid actuator test: unknown register 'missing'"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.registerNames, ","), func(t *testing.T) {
+			actuator := &config.Actuator{Name: "test", Registers: map[string]config.ActuatorRegisterMapValue{}}
+			for _, name := range tt.registerNames {
+				actuator.Registers[name] = config.ActuatorRegisterMapValue{}
+			}
+			err := registry.ValidateActuators(config.Actuators{"test": actuator})
+			if len(tt.err) > 0 {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
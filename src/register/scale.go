@@ -0,0 +1,59 @@
+package register
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sungrow-prometheus-exporter/src/config"
+)
+
+// linearMapping is applied natively instead of via expressions,
+// and is trivially invertible for writing
+type linearMapping struct {
+	scale, offset float64
+	decimals      int
+}
+
+func newLinearMapping(registerConfig *config.Register) (*linearMapping, error) {
+	if registerConfig.Scale == nil && registerConfig.Offset == 0 && registerConfig.Decimals == nil {
+		return nil, nil
+	}
+	if registerConfig.MapValue.ByFunction != nil {
+		return nil, fmt.Errorf("register %s cannot have both scale/offset/decimals and mapValue function", registerConfig.Name)
+	}
+	scale := 1.0
+	if registerConfig.Scale != nil {
+		scale = *registerConfig.Scale
+	}
+	if scale == 0 {
+		return nil, fmt.Errorf("register %s must not have scale 0", registerConfig.Name)
+	}
+	// by default, keep as many decimals as scale and offset have,
+	// which gets rid of floating point artifacts like 0.30000000000000004
+	decimals := countDecimals(scale)
+	if offsetDecimals := countDecimals(registerConfig.Offset); offsetDecimals > decimals {
+		decimals = offsetDecimals
+	}
+	if registerConfig.Decimals != nil {
+		decimals = *registerConfig.Decimals
+	}
+	return &linearMapping{scale, registerConfig.Offset, decimals}, nil
+}
+
+func countDecimals(value float64) int {
+	s := strconv.FormatFloat(value, 'f', -1, 64)
+	if _, fraction, found := strings.Cut(s, "."); found {
+		return len(fraction)
+	}
+	return 0
+}
+
+func (m *linearMapping) apply(value float64) float64 {
+	factor := math.Pow10(m.decimals)
+	return math.Round((value*m.scale+m.offset)*factor) / factor
+}
+
+func (m *linearMapping) invert(value float64) float64 {
+	return (value - m.offset) / m.scale
+}
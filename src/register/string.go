@@ -0,0 +1,119 @@
+package register
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sungrow-prometheus-exporter/src/config"
+	"sungrow-prometheus-exporter/src/util"
+	"unicode"
+	"unicode/utf16"
+)
+
+type stringRegister struct {
+	register
+	config.StringOptions
+}
+
+func newStringRegister(registerConfig *config.Register) *stringRegister {
+	options := registerConfig.String
+	if len(options.Encoding) == 0 {
+		options.Encoding = config.ASCIIStringEncoding
+	}
+	if len(options.Padding) == 0 {
+		options.Padding = config.NulStringPadding
+	}
+	return &stringRegister{
+		newRegister(registerConfig, registerConfig.Length),
+		options,
+	}
+}
+
+func (r *stringRegister) getAddressInterval() *util.Interval[uint16] {
+	return &util.Interval[uint16]{Start: r.baseAddress, End: r.baseAddress + r.width - 1}
+}
+
+func (r *stringRegister) getValueToWrite(_ Reader, valueProvider func() (string, *float64), _ config.RegisterValueProvider) ([]uint16, error) {
+	if !r.writable {
+		return nil, errNotWritable
+	}
+	value, _ := valueProvider()
+	return r.encode(value)
+}
+
+func (r *stringRegister) ReadFloat64(Reader, uint16) (float64, error) {
+	return 0, errors.New("string register does not have float64 representation")
+}
+
+func (r *stringRegister) ReadString(reader Reader) (string, error) {
+	data, err := r.read(reader, 0, r.width)
+	if err != nil {
+		return "", err
+	}
+	return r.decode(data), nil
+}
+
+func (r *stringRegister) paddingRune() rune {
+	if r.Padding == config.SpaceStringPadding {
+		return ' '
+	}
+	return 0
+}
+
+func (r *stringRegister) swap(word uint16) uint16 {
+	if r.ByteSwapped {
+		return word<<8 | word>>8
+	}
+	return word
+}
+
+func (r *stringRegister) decode(data []uint16) string {
+	var result string
+	if r.Encoding == config.UTF16StringEncoding {
+		result = string(utf16.Decode(util.MapSlice(data, r.swap)))
+	} else {
+		bytes := make([]byte, 0, 2*len(data))
+		for _, word := range data {
+			word = r.swap(word)
+			bytes = append(bytes, byte(word>>8), byte(word))
+		}
+		result = string(bytes)
+	}
+	// only trailing padding is removed, padding in the middle is kept as is
+	result = strings.TrimRight(result, string(r.paddingRune()))
+	if r.TrimSpace {
+		result = strings.TrimSpace(result)
+	}
+	return result
+}
+
+func (r *stringRegister) encode(value string) ([]uint16, error) {
+	var data []uint16
+	if r.Encoding == config.UTF16StringEncoding {
+		data = utf16.Encode([]rune(value))
+	} else {
+		for _, c := range value {
+			if c > unicode.MaxASCII {
+				return nil, fmt.Errorf("cannot encode non-ASCII character %q", c)
+			}
+		}
+		bytes := []byte(value)
+		if len(bytes)%2 == 1 {
+			bytes = append(bytes, byte(r.paddingRune()))
+		}
+		for i := 0; i < len(bytes); i += 2 {
+			data = append(data, uint16(bytes[i])<<8|uint16(bytes[i+1]))
+		}
+	}
+	if len(data) > int(r.width) {
+		return nil, fmt.Errorf("value '%s' exceeds length %d of string register", value, r.width)
+	}
+	padding := uint16(r.paddingRune())
+	if r.Encoding == config.ASCIIStringEncoding {
+		padding |= padding << 8
+	}
+	for len(data) < int(r.width) {
+		data = append(data, padding)
+	}
+	return util.MapSlice(data, r.swap), nil
+}
@@ -0,0 +1,45 @@
+package register
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sungrow-prometheus-exporter/src/config"
+	"testing"
+)
+
+func TestStringRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		options config.StringOptions
+		data    []uint16
+		value   string
+	}{
+		{"ascii", config.StringOptions{}, []uint16{0x4142, 0x4300, 0x0000}, "ABC"},
+		{"ascii byte swapped", config.StringOptions{ByteSwapped: true}, []uint16{0x4241, 0x0043, 0x0000}, "ABC"},
+		{"ascii space padded", config.StringOptions{Padding: config.SpaceStringPadding}, []uint16{0x4142, 0x4320, 0x2020}, "ABC"},
+		{"ascii nul in the middle", config.StringOptions{}, []uint16{0x4100, 0x4200, 0x0000}, "A\x00B"},
+		{"utf16", config.StringOptions{Encoding: config.UTF16StringEncoding}, []uint16{0x00c4, 0x0042, 0x0000}, "ÄB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newStringRegister(&config.Register{
+				Type:     config.StringRegisterType,
+				Length:   uint16(len(tt.data)),
+				Writable: true,
+				String:   tt.options,
+			})
+			value, err := reg.ReadString(fixedReader(tt.data))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.value, value)
+			data, err := reg.getValueToWrite(nil, func() (string, *float64) {
+				return tt.value, nil
+			}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.data, data)
+		})
+	}
+	reg := newStringRegister(&config.Register{Type: config.StringRegisterType, Length: 1, Writable: true})
+	_, err := reg.getValueToWrite(nil, func() (string, *float64) {
+		return "ABC", nil
+	}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Entry holds the state of one series, like an accumulated total
+type Entry map[string]float64
+
+// Store keeps state across exporter restarts in a JSON file,
+// an empty path keeps the state in memory only
+type Store struct {
+	path    string
+	mutex   sync.Mutex
+	entries map[string]Entry
+	dirty   bool
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, entries: map[string]Entry{}}
+	if len(path) == 0 {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns a copy of the entry, nil if missing
+func (s *Store) Get(key string) Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	result := Entry{}
+	for k, v := range entry {
+		result[k] = v
+	}
+	return result
+}
+
+// Update modifies the entry exclusively, a missing entry is created empty
+func (s *Store) Update(key string, update func(entry Entry)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = Entry{}
+		s.entries[key] = entry
+	}
+	update(entry)
+	s.dirty = true
+}
+
+// Save writes the file if entries were updated,
+// replacing it at once to not leave a partial file
+func (s *Store) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.dirty || len(s.path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(data); err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile.Name(), s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
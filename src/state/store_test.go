@@ -0,0 +1,42 @@
+package state
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save())
+	assert.NoFileExists(t, path)
+
+	store.Update("energy", func(entry Entry) {
+		entry["total"] += 2
+	})
+	store.Update("energy", func(entry Entry) {
+		entry["total"] += 3
+	})
+	assert.NoError(t, store.Save())
+
+	store, err = NewStore(path)
+	assert.NoError(t, err)
+	assert.Equal(t, Entry{"total": 5}, store.Get("energy"))
+	assert.Nil(t, store.Get("power"))
+
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = NewStore(path)
+	assert.Error(t, err)
+}
+
+func TestStore_InMemory(t *testing.T) {
+	store, err := NewStore("")
+	assert.NoError(t, err)
+	store.Update("energy", func(entry Entry) {
+		entry["total"] = 1
+	})
+	assert.NoError(t, store.Save())
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/antonmedv/expr/ast"
 	"github.com/antonmedv/expr/parser"
 	"github.com/antonmedv/expr/vm"
+	"golang.org/x/exp/slices"
 )
 
 type EnvEntry struct {
@@ -22,21 +23,69 @@ func Env(identifier string, value interface{}) *EnvEntry {
 	return &EnvEntry{identifier, value}
 }
 
+// And does not modify the given entries,
+// such that it can be called concurrently on shared entries
 func (e EnvEntry) And(es []*EnvEntry) []*EnvEntry {
-	return append(es, &e)
+	return append(append(make([]*EnvEntry, 0, len(es)+1), es...), &e)
 }
 
+// BuildEnv always includes the stdlib,
+// which is overridden by the given entries
 func BuildEnv(envs ...*EnvEntry) map[string]interface{} {
 	return MapFromNamedSlice(func(entry *EnvEntry) interface{} {
 		return entry.value
-	}, envs...)
+	}, append(append(make([]*EnvEntry, 0, len(stdlib)+len(envs)), stdlib...), envs...)...)
 }
 
-func Compile(input string) (*vm.Program, error) {
-	return expr.Compile(input)
+func Compile(input string, ops ...expr.Option) (*vm.Program, error) {
+	return expr.Compile(input, ops...)
 }
 
-func InvertAndCompile(input string) (*vm.Program, error) {
+// FindFunctionCalls collects the first argument of calls to the given functions,
+// which must be a string literal like the register name in register('name')
+func FindFunctionCalls(input string, functionNames ...string) (map[string][]string, error) {
+	tree, err := parser.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	v := functionCallVisitor{functionNames: functionNames, calls: map[string][]string{}}
+	ast.Walk(&tree.Node, &v)
+	if v.err != nil {
+		return nil, v.err
+	}
+	return v.calls, nil
+}
+
+type functionCallVisitor struct {
+	functionNames []string
+	calls         map[string][]string
+	err           error
+}
+
+func (v *functionCallVisitor) Enter(node *ast.Node) {
+	n, ok := (*node).(*ast.FunctionNode)
+	if !ok || !slices.Contains(v.functionNames, n.Name) {
+		return
+	}
+	if len(n.Arguments) == 0 {
+		v.err = fmt.Errorf("%s expects at least one argument", n.Name)
+		return
+	}
+	argument, ok := n.Arguments[0].(*ast.StringNode)
+	if !ok {
+		if v.err == nil { // keep first error while walking
+			v.err = fmt.Errorf("first argument of %s must be a string literal", n.Name)
+		}
+		return
+	}
+	v.calls[n.Name] = append(v.calls[n.Name], argument.Value)
+}
+
+func (v *functionCallVisitor) Exit(*ast.Node) {
+
+}
+
+func InvertAndCompile(input string, ops ...expr.Option) (*vm.Program, error) {
 	tree, err := parser.Parse(input)
 	if err != nil {
 		return nil, err
@@ -45,7 +94,7 @@ func InvertAndCompile(input string) (*vm.Program, error) {
 	if err != nil {
 		return nil, err
 	}
-	return expr.Compile(nodeToString(inverse))
+	return expr.Compile(nodeToString(inverse), ops...)
 }
 
 func nodeToString(node *ast.Node) string {
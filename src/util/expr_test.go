@@ -43,3 +43,11 @@ func TestInvertAndCompile(t *testing.T) {
 		})
 	}
 }
+
+func TestFindFunctionCalls(t *testing.T) {
+	calls, err := FindFunctionCalls("register('a') + (x > 0 ? register('b', 2) : sum(registerArray('c')))", "register", "registerArray")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"register": {"a", "b"}, "registerArray": {"c"}}, calls)
+	_, err = FindFunctionCalls("register(name)", "register")
+	assert.EqualError(t, err, "first argument of register must be a string literal")
+}
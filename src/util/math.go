@@ -14,6 +14,14 @@ func Min[T constraints.Ordered](a, b T) T {
 	}
 }
 
+func Max[T constraints.Ordered](a, b T) T {
+	if a > b {
+		return a
+	} else {
+		return b
+	}
+}
+
 func NumericToFloat64(v interface{}) float64 {
 	switch v.(type) {
 	case float64:
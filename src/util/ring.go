@@ -0,0 +1,32 @@
+package util
+
+// Ring keeps the latest elements up to its capacity,
+// adding to a full ring overwrites the oldest element
+type Ring[T any] struct {
+	elements []T
+	start    int
+	length   int
+}
+
+func NewRing[T any](capacity int) *Ring[T] {
+	return &Ring[T]{elements: make([]T, Max(capacity, 1))}
+}
+
+func (r *Ring[T]) Add(element T) {
+	end := (r.start + r.length) % len(r.elements)
+	r.elements[end] = element
+	if r.length < len(r.elements) {
+		r.length++
+	} else {
+		r.start = (r.start + 1) % len(r.elements)
+	}
+}
+
+// Elements returns a copy, oldest first
+func (r *Ring[T]) Elements() []T {
+	result := make([]T, r.length)
+	for i := range result {
+		result[i] = r.elements[(r.start+i)%len(r.elements)]
+	}
+	return result
+}
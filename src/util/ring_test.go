@@ -0,0 +1,18 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	ring := NewRing[int](3)
+	assert.Equal(t, []int{}, ring.Elements())
+	ring.Add(1)
+	ring.Add(2)
+	assert.Equal(t, []int{1, 2}, ring.Elements())
+	ring.Add(3)
+	ring.Add(4)
+	ring.Add(5)
+	assert.Equal(t, []int{3, 4, 5}, ring.Elements())
+}
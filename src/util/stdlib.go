@@ -0,0 +1,200 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// localLocation is the timezone of the inverter, see SetLocalLocation
+var localLocation = time.Local
+
+// SetLocalLocation sets the timezone used by the local time helpers,
+// must be called before expressions are evaluated
+func SetLocalLocation(location *time.Location) {
+	localLocation = location
+}
+
+// LocalMidnight is the start of the day of the time in the timezone of the inverter
+func LocalMidnight(t time.Time) time.Time {
+	t = t.In(localLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, localLocation)
+}
+
+// stdlib is available in every expression, see BuildEnv
+var stdlib = []*EnvEntry{
+	Env("sum", func(args ...interface{}) (float64, error) {
+		return aggregate(args, 0, func(result, value float64) float64 {
+			return result + value
+		})
+	}),
+	Env("max", func(args ...interface{}) (float64, error) {
+		return aggregate(args, math.Inf(-1), math.Max)
+	}),
+	Env("min", func(args ...interface{}) (float64, error) {
+		return aggregate(args, math.Inf(1), math.Min)
+	}),
+	Env("avg", func(args ...interface{}) (float64, error) {
+		values, err := flattenToFloat64s(args)
+		if err != nil {
+			return 0, err
+		}
+		if len(values) == 0 {
+			return math.NaN(), nil
+		}
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum / float64(len(values)), nil
+	}),
+	Env("abs", func(args ...interface{}) (float64, error) {
+		values, err := expectNumericArgs("abs", args, 1, 1)
+		if err != nil {
+			return 0, err
+		}
+		return math.Abs(values[0]), nil
+	}),
+	Env("round", func(args ...interface{}) (float64, error) {
+		values, err := expectNumericArgs("round", args, 1, 2)
+		if err != nil {
+			return 0, err
+		}
+		factor := 1.0
+		if len(values) == 2 {
+			factor = math.Pow10(int(values[1]))
+		}
+		return math.Round(values[0]*factor) / factor, nil
+	}),
+	Env("clamp", func(args ...interface{}) (float64, error) {
+		values, err := expectNumericArgs("clamp", args, 3, 3)
+		if err != nil {
+			return 0, err
+		}
+		return math.Max(values[1], math.Min(values[2], values[0])), nil
+	}),
+	Env("now", time.Now),
+	Env("timeDate", func(args ...interface{}) (time.Time, error) {
+		if len(args) != 7 {
+			return time.Time{}, fmt.Errorf("timeDate expects 7 arguments, got %d", len(args))
+		}
+		timezone, ok := args[6].(string)
+		if !ok {
+			return time.Time{}, fmt.Errorf("timezone %v is not a string", args[6])
+		}
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, err
+		}
+		values, err := expectNumericArgs("timeDate", args[:6], 6, 6)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Date(
+			int(values[0]),
+			time.Month(values[1]),
+			int(values[2]),
+			int(values[3]),
+			int(values[4]),
+			int(values[5]),
+			0,
+			location,
+		), nil
+	}),
+	Env("timeParse", func(value, layout, timezone string) (time.Time, error) {
+		parsed, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return parsed.In(location), nil
+	}),
+	// local time helpers return plain integers,
+	// which can be used for arithmetic and as array index
+	Env("localNow", func() time.Time {
+		return time.Now().In(localLocation)
+	}),
+	Env("localYear", func() int {
+		return time.Now().In(localLocation).Year()
+	}),
+	Env("localMonth", func() int {
+		return int(time.Now().In(localLocation).Month())
+	}),
+	Env("localDay", func() int {
+		return time.Now().In(localLocation).Day()
+	}),
+	Env("localHour", func() int {
+		return time.Now().In(localLocation).Hour()
+	}),
+	Env("localMinute", func() int {
+		return time.Now().In(localLocation).Minute()
+	}),
+	Env("localWeekday", func() int {
+		return int(time.Now().In(localLocation).Weekday())
+	}),
+	// localSlot is the index of the current slot of the day,
+	// e.g. localSlot(15) for registers with quarter-hourly values
+	Env("localSlot", func(minutes int) (int, error) {
+		if minutes <= 0 {
+			return 0, fmt.Errorf("slot of %d minutes must be positive", minutes)
+		}
+		now := time.Now().In(localLocation)
+		return (60*now.Hour() + now.Minute()) / minutes, nil
+	}),
+}
+
+func aggregate(args []interface{}, initial float64, accumulate func(result, value float64) float64) (float64, error) {
+	values, err := flattenToFloat64s(args)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) == 0 {
+		return math.NaN(), nil
+	}
+	result := initial
+	for _, value := range values {
+		result = accumulate(result, value)
+	}
+	return result, nil
+}
+
+// flattenToFloat64s accepts arrays, like from registerArray, and single numbers
+func flattenToFloat64s(args []interface{}) ([]float64, error) {
+	var result []float64
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case []float64:
+			result = append(result, v...)
+		case []interface{}:
+			values, err := flattenToFloat64s(v)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, values...)
+		case float64, int, int64:
+			result = append(result, NumericToFloat64(v))
+		default:
+			return nil, fmt.Errorf("cannot aggregate non-numeric value %v", arg)
+		}
+	}
+	return result, nil
+}
+
+func expectNumericArgs(name string, args []interface{}, minArgs, maxArgs int) ([]float64, error) {
+	if len(args) < minArgs || len(args) > maxArgs {
+		return nil, fmt.Errorf("%s expects %d to %d arguments, got %d", name, minArgs, maxArgs, len(args))
+	}
+	result := make([]float64, len(args))
+	for i, arg := range args {
+		switch arg.(type) {
+		case float64, int, int64:
+			result[i] = NumericToFloat64(arg)
+		default:
+			return nil, fmt.Errorf("argument %v of %s is not numeric", arg, name)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"github.com/antonmedv/expr"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStdlib(t *testing.T) {
+	SetLocalLocation(time.UTC)
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"min(3, 1, 2)", 1.0},
+		{"max([3, 1], 2)", 3.0},
+		{"sum(values)", 6.0},
+		{"avg(values)", 2.0},
+		{"abs(-2.5)", 2.5},
+		{"round(2.5)", 3.0},
+		{"round(1.2345, 2)", 1.23},
+		{"clamp(120, 0, 100)", 100.0},
+		{"clamp(-5, 0, 100)", 0.0},
+		{"timeDate(2022, 3, 27, 1, 59, 0, 'Europe/Berlin').Unix()", int64(1648342740)},
+		{"timeParse('14:30', '15:04', 'UTC').Minute()", 30},
+		{"localMonth() == int(now().Month())", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := expr.Eval(tt.input, BuildEnv(
+				Env("values", []float64{1, 2, 3}),
+				Env("int", func(m time.Month) int { return int(m) }),
+			))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+	result, err := expr.Eval("max()", BuildEnv())
+	assert.NoError(t, err)
+	assert.True(t, math.IsNaN(result.(float64)))
+	_, err = expr.Eval("abs('x')", BuildEnv())
+	assert.Error(t, err)
+}
+
+func TestBuildEnv_Override(t *testing.T) {
+	result, err := expr.Eval("abs(1)", BuildEnv(Env("abs", func(int) int { return 42 })))
+	assert.NoError(t, err)
+	assert.Equal(t, 42, result)
+}